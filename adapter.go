@@ -13,6 +13,36 @@ type (
 		DBusObjectProxy
 		iface string
 	}
+
+	// AdapterProperties holds Adapter1 properties, nil fields are
+	// unknown.
+	AdapterProperties struct {
+		Address              *string
+		AddressType          *string
+		Name                 *string
+		Alias                *string
+		Class                *uint32
+		Powered              *bool
+		PowerState           *string
+		Discoverable         *bool
+		DiscoverableTimeout  *time.Duration
+		Pairable             *bool
+		PairableTimeout      *time.Duration
+		Discovering          *bool
+		UUIDs                []string
+		Modalias             *string
+		Roles                []string
+		ExperimentalFeatures []string
+	}
+
+	// AdapterChange reports the properties of an adapter that changed,
+	// and those that were invalidated.
+	AdapterChange struct {
+		Path        string
+		Changed     AdapterProperties
+		Invalidated []string
+		T           time.Time
+	}
 )
 
 const (
@@ -25,74 +55,86 @@ func NewAdapter(conn *dbus.Conn, path string) *Adapter {
 	debug("NewAdapter(%v, %v)", conn, path)
 
 	return &Adapter{
-		DBusObjectProxy: newDBusObjectProxy(conn, destOrgBluez, "org.bluez.Adapter1", path),
+		DBusObjectProxy: newDBusObjectProxy(conn, destOrgBluez, "org.bluez.Adapter1", path, "Address", "AddressType"),
 	}
 }
 
-func (a *Adapter) SetDiscoveryFilter(filter map[string]interface{}) error {
-	debug("Adapter.SetDiscoveryFilter(ctx, %v)", filter)
-	return a.Call("org.bluez.Adapter1.SetDiscoveryFilter", 0, filter).Store()
-}
+// SetDiscoveryFilter validates the filter against the filters supported by
+// the adapter and sets it.
+func (a *Adapter) SetDiscoveryFilter(filter DiscoveryFilter) error {
+	debug("Adapter.SetDiscoveryFilter(%v)", filter)
 
-func (a *Adapter) StartDiscovery(ctx context.Context) ([]interface{}, error) {
-	debug("Adapter.StartDiscovery(ctx)")
+	supported, err := a.GetDiscoveryFilters()
+	if err != nil {
+		return err
+	}
 
-	v := make([]interface{}, 0)
-	if err := a.CallWithContext(ctx, "org.bluez.Adapter1.StartDiscovery", 0).Store(v); err != nil {
-		return nil, err
+	if err := filter.Validate(supported); err != nil {
+		return err
 	}
 
-	return v, nil
+	return a.Call("org.bluez.Adapter1.SetDiscoveryFilter", 0, filter.Variants()).Store()
 }
 
-func (a *Adapter) Address() (string, error) {
-	return a.GetStringProperty("Address")
-}
+// RemoveDevice removes the device and its pairing information.
+func (a *Adapter) RemoveDevice(ctx context.Context, d *Device) error {
+	debug("Adapter.RemoveDevice(%v)", d.Path())
 
-func (a *Adapter) AddressType() (string, error) {
-	return a.GetStringProperty("AddressType")
+	return a.CallWithContext(ctx, "org.bluez.Adapter1.RemoveDevice", 0, d.Path()).Store()
 }
 
-func (a *Adapter) Name() (string, error) {
-	return a.GetStringProperty("Name")
-}
+// ConnectDevice connects to a device that wasn't discovered, addressType is
+// "public" or "random". BlueZ only offers it with experimental features
+// enabled.
+func (a *Adapter) ConnectDevice(ctx context.Context, address, addressType string) (*Device, error) {
+	debug("Adapter.ConnectDevice(%v, %v)", address, addressType)
 
-func (a *Adapter) Alias() (string, error) {
-	return a.GetStringProperty("Alias")
-}
+	params := map[string]dbus.Variant{
+		"Address": dbus.MakeVariant(address),
+	}
 
-func (a *Adapter) Class() (uint32, error) {
-	return a.GetUint32Property("Class")
-}
+	if addressType != "" {
+		params["AddressType"] = dbus.MakeVariant(addressType)
+	}
 
-func (a *Adapter) SetPowered(powered bool) error {
-	return a.SetProperty("Powered", powered)
-}
+	var path dbus.ObjectPath
+	if err := a.CallWithContext(ctx, "org.bluez.Adapter1.ConnectDevice", 0, params).Store(&path); err != nil {
+		return nil, err
+	}
 
-func (a *Adapter) Powered() (bool, error) {
-	return a.GetBoolProperty("Powered")
+	return NewDevice(a.conn, string(path)), nil
 }
 
-func (a *Adapter) Discoverable() (bool, error) {
-	return a.GetBoolProperty("Discoverable")
+// Properties reads every property of the adapter with a single call.
+func (a *Adapter) Properties() (AdapterProperties, error) {
+	var p AdapterProperties
+	return p, a.GetAll(&p)
 }
 
-func (a *Adapter) Pairable() (bool, error) {
-	return a.GetBoolProperty("Pairable")
-}
+// Watch reports the property changes of the adapter until ctx is done.
+func (a *Adapter) Watch(ctx context.Context) (<-chan AdapterChange, error) {
+	ch := make(chan AdapterChange)
 
-func (a *Adapter) PairableTimeout() (time.Duration, error) {
-	return a.GetDurationProperty("Pairable")
-}
+	newChanged := func() interface{} { return &AdapterProperties{} }
+	send := func(c PropertyChange, changed interface{}) bool {
+		e := AdapterChange{
+			Path:        string(c.Path),
+			Changed:     *changed.(*AdapterProperties),
+			Invalidated: c.Invalidated,
+			T:           c.T,
+		}
 
-func (a *Adapter) DiscoverableTimeout() (time.Duration, error) {
-	return a.GetDurationProperty("DiscoverableTimeout")
-}
+		select {
+		case ch <- e:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
 
-func (a *Adapter) Discovering() (bool, error) {
-	return a.GetBoolProperty("Discovering")
-}
+	if err := a.watchDecoded(ctx, newChanged, send, func() { close(ch) }); err != nil {
+		return nil, err
+	}
 
-func (a *Adapter) UUIDS() ([]string, error) {
-	return a.GetStringSliceProperty("UUIDS")
+	return ch, nil
 }
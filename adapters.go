@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+type (
+	// AdapterInfo describes an adapter of the object tree.
+	AdapterInfo struct {
+		Path    string
+		Address string
+		Name    string
+		Alias   string
+		Powered bool
+	}
+
+	// ActiveAdapter is the adapter currently in use, it's a Scanner that
+	// runs discovery sessions on whichever adapter is active.
+	ActiveAdapter struct {
+		mut     sync.RWMutex
+		adapter *Adapter
+	}
+)
+
+var ErrNoAdapter = errors.New("no adapter available")
+
+func newAdapterInfo(path string, attrs map[string]interface{}) AdapterInfo {
+	a := AdapterInfo{Path: path}
+
+	a.Address, _ = attrs["Address"].(string)
+	a.Name, _ = attrs["Name"].(string)
+	a.Alias, _ = attrs["Alias"].(string)
+	a.Powered, _ = attrs["Powered"].(bool)
+
+	return a
+}
+
+// Match reports whether the adapter is the one configured. Every criterion
+// that is set must match, Name matches either the name or the alias of the
+// adapter. With no criteria at all any adapter matches.
+func (c AdapterConfig) Match(a AdapterInfo) bool {
+	if c.Path != "" && a.Path != c.Path {
+		return false
+	}
+
+	if c.Address != "" && !strings.EqualFold(a.Address, c.Address) {
+		return false
+	}
+
+	if c.Name != "" && a.Name != c.Name && a.Alias != c.Name {
+		return false
+	}
+
+	return true
+}
+
+// Pick chooses the adapter to use among adapters, preferring powered ones.
+// If no adapter matches the configuration and Failover is set, any other
+// adapter is used instead.
+func (c AdapterConfig) Pick(adapters []AdapterInfo) (AdapterInfo, error) {
+	var preferred, others []AdapterInfo
+	for _, a := range adapters {
+		if c.Match(a) {
+			preferred = append(preferred, a)
+		} else {
+			others = append(others, a)
+		}
+	}
+
+	if a, ok := firstAdapter(preferred); ok {
+		return a, nil
+	}
+
+	if c.Failover {
+		if a, ok := firstAdapter(others); ok {
+			return a, nil
+		}
+	}
+
+	return AdapterInfo{}, ErrNoAdapter
+}
+
+// firstAdapter returns the first powered adapter, or failing that the first
+// adapter.
+func firstAdapter(adapters []AdapterInfo) (AdapterInfo, bool) {
+	for _, a := range adapters {
+		if a.Powered {
+			return a, true
+		}
+	}
+
+	if len(adapters) != 0 {
+		return adapters[0], true
+	}
+
+	return AdapterInfo{}, false
+}
+
+// WatchAdapter sends the adapter picked by cfg every time it changes,
+// because an adapter was added or removed, or because the picked adapter
+// was powered on or off. A zero AdapterInfo is sent when no adapter is
+// available. The channel is closed when ctx is done.
+func (m *ObjectManager) WatchAdapter(ctx context.Context, cfg AdapterConfig) <-chan AdapterInfo {
+	ch := make(chan AdapterInfo)
+
+	go func() {
+		defer close(ch)
+
+		var last *AdapterInfo
+
+		for {
+			changed := m.Changed()
+
+			// ErrNoAdapter leaves a zero AdapterInfo
+			a, _ := cfg.Pick(m.Adapters())
+
+			if last == nil || a.Path != last.Path || a.Powered != last.Powered {
+				select {
+				case ch <- a:
+					last = &a
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// OnAdapter is a DeviceMatch accepting the devices of the adapter at path
+// that match is accepting too.
+func OnAdapter(path string, match DeviceMatch) DeviceMatch {
+	return func(p string, attrs map[string]interface{}) bool {
+		return objectPathAttr(attrs, "Adapter") == path && match(p, attrs)
+	}
+}
+
+// Set makes a the active adapter, nil if there is none.
+func (aa *ActiveAdapter) Set(a *Adapter) {
+	aa.mut.Lock()
+	defer aa.mut.Unlock()
+
+	aa.adapter = a
+}
+
+// Get returns the active adapter.
+func (aa *ActiveAdapter) Get() (*Adapter, error) {
+	aa.mut.RLock()
+	defer aa.mut.RUnlock()
+
+	if aa.adapter == nil {
+		return nil, ErrNoAdapter
+	}
+
+	return aa.adapter, nil
+}
+
+// Discover runs a discovery session on the active adapter.
+func (aa *ActiveAdapter) Discover(ctx context.Context, opts DiscoveryOptions) (<-chan DiscoveryEvent, error) {
+	a, err := aa.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	return a.Discover(ctx, opts)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAdapterConfigMatch(t *testing.T) {
+	a := AdapterInfo{
+		Path:    "/org/bluez/hci1",
+		Address: "00:1A:7D:DA:71:13",
+		Name:    "pi",
+		Alias:   "Dongle",
+	}
+
+	tests := []struct {
+		name string
+		cfg  AdapterConfig
+		want bool
+	}{
+		{"no criteria", AdapterConfig{}, true},
+		{"path", AdapterConfig{Path: "/org/bluez/hci1"}, true},
+		{"other path", AdapterConfig{Path: "/org/bluez/hci0"}, false},
+		{"address any case", AdapterConfig{Address: "00:1a:7d:da:71:13"}, true},
+		{"other address", AdapterConfig{Address: "00:1A:7D:DA:71:14"}, false},
+		{"name", AdapterConfig{Name: "pi"}, true},
+		{"alias", AdapterConfig{Name: "Dongle"}, true},
+		{"other name", AdapterConfig{Name: "hci1"}, false},
+		{"all", AdapterConfig{Path: "/org/bluez/hci1", Address: "00:1A:7D:DA:71:13", Name: "pi"}, true},
+		{"one of all", AdapterConfig{Path: "/org/bluez/hci1", Address: "00:1A:7D:DA:71:13", Name: "x"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.Match(a); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdapterConfigPick(t *testing.T) {
+	hci0 := AdapterInfo{Path: "/org/bluez/hci0", Address: "00:00:00:00:00:00"}
+	hci1 := AdapterInfo{Path: "/org/bluez/hci1", Address: "11:11:11:11:11:11", Powered: true}
+	hci2 := AdapterInfo{Path: "/org/bluez/hci2", Address: "22:22:22:22:22:22", Name: "usb"}
+
+	tests := []struct {
+		name     string
+		cfg      AdapterConfig
+		adapters []AdapterInfo
+		want     string
+		err      error
+	}{
+		{"none", AdapterConfig{}, nil, "", ErrNoAdapter},
+		{"powered first", AdapterConfig{}, []AdapterInfo{hci0, hci1, hci2}, hci1.Path, nil},
+		{"unpowered if nothing else", AdapterConfig{}, []AdapterInfo{hci0, hci2}, hci0.Path, nil},
+		{"matching over powered", AdapterConfig{Name: "usb"}, []AdapterInfo{hci0, hci1, hci2}, hci2.Path, nil},
+		{"no match", AdapterConfig{Name: "usb"}, []AdapterInfo{hci0, hci1}, "", ErrNoAdapter},
+		{"failover", AdapterConfig{Name: "usb", Failover: true}, []AdapterInfo{hci0, hci1}, hci1.Path, nil},
+		{"failover with none", AdapterConfig{Name: "usb", Failover: true}, nil, "", ErrNoAdapter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cfg.Pick(tt.adapters)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+
+			if got.Path != tt.want {
+				t.Errorf("got %q, want %q", got.Path, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewAdapterInfo(t *testing.T) {
+	a := newAdapterInfo("/org/bluez/hci0", map[string]interface{}{
+		"Address": "00:1A:7D:DA:71:13",
+		"Name":    "pi",
+		"Alias":   "Dongle",
+		"Powered": true,
+		"Class":   uint32(0x6c0000),
+	})
+
+	want := AdapterInfo{Path: "/org/bluez/hci0", Address: "00:1A:7D:DA:71:13", Name: "pi", Alias: "Dongle", Powered: true}
+	if a != want {
+		t.Errorf("got %+v, want %+v", a, want)
+	}
+}
@@ -2,84 +2,260 @@ package main
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"log"
 	"math"
+	"sync"
 	"time"
-
-	dbus "github.com/godbus/dbus/v5"
 )
 
 const (
 	fff0UUID = "0000fff0-0000-1000-8000-00805f9b34fb"
 	fff1UUID = "0000fff1-0000-1000-8000-00805f9b34fb"
+	fff2UUID = "0000fff2-0000-1000-8000-00805f9b34fb"
 	fff3UUID = "0000fff3-0000-1000-8000-00805f9b34fb"
+	fff4UUID = "0000fff4-0000-1000-8000-00805f9b34fb"
 	fff5UUID = "0000fff5-0000-1000-8000-00805f9b34fb"
+
+	// rawUnplugged is what the device reports for a probe that isn't
+	// plugged in
+	rawUnplugged = 0xfff6
+
+	// loginSettle is how long to wait for the device to drop the
+	// connection after a rejected login
+	loginSettle = 500 * time.Millisecond
 )
 
 type (
 	BbqDB interface {
-		PushTemperatures(temps []int16, t time.Time) error
+		PushMeasurement(m Measurement) error
 	}
 
+	// Reading is the temperature of a single probe. Value is meaningless
+	// if Unplugged is set.
+	Reading struct {
+		Value     Temperature
+		Unplugged bool
+	}
+
+	// Measurement is the temperatures of all probes of a device at time
+	// T. Device and Alias identify the device, they are filled in by the
+	// Session reading it.
 	Measurement struct {
-		Temperatures []int16
+		Device       string
+		Alias        string
+		Temperatures []Reading
 		T            time.Time
 	}
 
+	// ibbqDriver is the Driver for iBBQ thermometers, such as those sold
+	// by Inkbird.
+	ibbqDriver struct{}
+
+	// LoginError is returned by NewBbq when the account and verify
+	// handshake fails.
+	LoginError struct {
+		Err error
+	}
+
 	Bbq struct {
-		dev      *Device
-		tempPath dbus.ObjectPath
-		events   chan Measurement
-		matchers []*SignalMatcher
+		dev          *Device
+		tempChar     *GattCharacteristic
+		settingsChar *GattCharacteristic
+		historyChar  *GattCharacteristic
+		control      *GattCharacteristic
+		events       chan Measurement
+		settings     chan SettingsEvent
+		history      chan Measurement
+
+		// stopWatch stops following the characteristic values
+		stopWatch context.CancelFunc
+
+		// sockMut protects the sockets acquired for the temperature
+		// notifications and the control writes, nil when the bus is
+		// used instead
+		sockMut     sync.Mutex
+		tempSock    *CharacteristicSocket
+		controlSock *CharacteristicSocket
+
+		// historyMut protects the state of the ongoing history
+		// download
+		historyMut       sync.Mutex
+		historyRequested time.Time
+		historySince     time.Time
+
+		// closeMut protects the event channels from being written to
+		// after they are closed
+		closeMut sync.RWMutex
+		closed   bool
 	}
 )
 
-func NewBbq(dev *Device) (*Bbq, error) {
-	if err := dev.Connect(context.Background()); err != nil {
+var (
+	ErrLoginRejected = errors.New("login rejected by device")
+	ErrInvalidProbe  = errors.New("invalid probe")
+	ErrInvalidTarget = errors.New("invalid target temperature")
+)
+
+func init() {
+	RegisterDriver(ibbqDriver{})
+}
+
+func (ibbqDriver) Name() string {
+	return "ibbq"
+}
+
+// Match accepts devices advertising the fff0 service, or, as the UUIDs
+// aren't always cached, the name all iBBQ devices advertise.
+func (ibbqDriver) Match(d *Device) bool {
+	if hasUUID(d, fff0UUID) {
+		return true
+	}
+
+	name, err := d.Name()
+
+	return err == nil && name == "BBQ"
+}
+
+func (ibbqDriver) Connect(d *Device, cfg DeviceConfig) (Thermometer, error) {
+	key, err := cfg.KeyBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewBbq(d, key)
+}
+
+func (e *LoginError) Error() string {
+	return fmt.Sprintf("login failed, %v", e.Err)
+}
+
+func (e *LoginError) Unwrap() error {
+	return e.Err
+}
+
+func NewBbq(dev *Device, key []byte) (*Bbq, error) {
+	// The device may already have been connected by a Supervisor
+	connected, err := dev.Connected()
+	if err != nil {
 		return nil, err
 	}
 
-	tempPath, err := dev.Characteristic(fff5UUID)
+	if !connected {
+		if err := dev.Connect(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := login(dev, key); err != nil {
+		return nil, err
+	}
+
+	tempChar, err := dev.Characteristic(fff5UUID)
+	if err != nil {
+		return nil, err
+	}
+
+	settingsChar, err := dev.Characteristic(fff1UUID)
+	if err != nil {
+		return nil, err
+	}
+
+	historyChar, err := dev.Characteristic(fff3UUID)
+	if err != nil {
+		return nil, err
+	}
+
+	control, err := dev.Characteristic(fff4UUID)
 	if err != nil {
 		return nil, err
 	}
 
 	b := &Bbq{
-		dev:      dev,
-		tempPath: tempPath.Path(),
-		events:   make(chan Measurement, 1),
+		dev:          dev,
+		tempChar:     tempChar,
+		settingsChar: settingsChar,
+		historyChar:  historyChar,
+		control:      control,
+		events:       make(chan Measurement, 1),
+		settings:     make(chan SettingsEvent, 8),
+		history:      make(chan Measurement, 64),
 	}
 
-	if err := b.setupSignalMatchers(); err != nil {
+	if err := b.watchValues(); err != nil {
 		return nil, err
 	}
 
 	if err := b.startNotifications(); err != nil {
+		b.teardown()
 		return nil, err
 	}
 
 	return b, nil
 }
 
-func (b *Bbq) setupSignalMatchers() error {
-	b.matchers = make([]*SignalMatcher, 0)
+// login performs the iBBQ account and verify handshake. Devices that require
+// it won't send any notifications until it is done and disconnect if the
+// credentials are rejected.
+func login(dev *Device, key []byte) error {
+	fff2, err := dev.Characteristic(fff2UUID)
+	if err != nil {
+		return &LoginError{err}
+	}
+
+	if err := fff2.WriteValue(key, nil); err != nil {
+		return &LoginError{err}
+	}
+
+	time.Sleep(loginSettle)
 
-	tempChar, err := b.dev.Characteristic(fff5UUID)
+	connected, err := dev.Connected()
 	if err != nil {
-		return err
+		return &LoginError{err}
+	}
+
+	if !connected {
+		return &LoginError{ErrLoginRejected}
 	}
 
-	tempPath := tempChar.Path()
+	return nil
+}
+
+// watchValues hands the value changes of the temperature, settings result
+// and history characteristics to their handlers until the Bbq is closed.
+func (b *Bbq) watchValues() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	b.stopWatch = cancel
 
-	// For a description of matching rules see
-	// https://dbus.freedesktop.org/doc/dbus-specification.html#message-bus-routing-match-rules
-	m := NewSignalMatcher(b.handleTemperatureUpdate,
-		dbus.WithMatchObjectPath(tempPath),
-		dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
-		dbus.WithMatchMember("PropertiesChanged"),
-	)
+	handlers := map[*GattCharacteristic]func(data []byte, t time.Time){
+		b.tempChar:     b.handleTemperatureUpdate,
+		b.settingsChar: b.handleSettingsResult,
+		b.historyChar:  b.handleHistory,
+	}
 
-	b.matchers = append(b.matchers, m)
+	for c, handler := range handlers {
+		changes, err := c.WatchProperties(ctx, "Value")
+		if err != nil {
+			cancel()
+			return err
+		}
+
+		go func(changes <-chan PropertyChange, handler func([]byte, time.Time)) {
+			for c := range changes {
+				var data []byte
+				if ok, err := c.Value("Value", &data); err != nil {
+					log.Print("PropertyChange.Value() failed, ", err)
+					continue
+				} else if !ok {
+					continue
+				}
+
+				handler(data, c.T)
+			}
+		}(changes, handler)
+	}
 
 	return nil
 }
@@ -106,14 +282,12 @@ func (b *Bbq) startNotifications() error {
 		return err
 	}
 
-	fff5, err := s.Characteristic(fff5UUID)
-	if err != nil {
-		return err
-	}
-	if err = fff5.StartNotify(); err != nil {
+	if err := b.startTemperature(); err != nil {
 		return err
 	}
 
+	b.acquireControl()
+
 	payloads := [][]byte{
 		[]byte{0x23, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00, 0x23},
 		[]byte{0x22, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x22},
@@ -125,13 +299,8 @@ func (b *Bbq) startNotifications() error {
 		[]byte{0x24, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x24},
 	}
 
-	fff4, err := s.Characteristic("0000fff4-0000-1000-8000-00805f9b34fb")
-	if err != nil {
-		return err
-	}
-
 	for _, payload := range payloads {
-		if err := fff4.WriteValue(payload, nil); err != nil {
+		if err := b.writeControl(payload); err != nil {
 			return err
 		}
 	}
@@ -139,85 +308,323 @@ func (b *Bbq) startNotifications() error {
 	return nil
 }
 
-func (b *Bbq) handleTemperatureUpdate(s *dbus.Signal) {
-	log.Printf("SignalMatcher.Match(signal=%v)", s)
+// startTemperature starts the temperature notifications, on a socket if
+// BlueZ supports AcquireNotify, as PropertiesChanged signals otherwise.
+func (b *Bbq) startTemperature() error {
+	sock, err := b.tempChar.AcquireNotify(context.Background())
+	if err != nil {
+		debug("AcquireNotify() failed, falling back to StartNotify(), %v", err)
+		return b.tempChar.StartNotify()
+	}
+
+	b.sockMut.Lock()
+	b.tempSock = sock
+	b.sockMut.Unlock()
+
+	go b.readTemperature(sock)
+
+	return nil
+}
+
+// readTemperature reads the temperature notifications from sock until it's
+// closed.
+func (b *Bbq) readTemperature(sock *CharacteristicSocket) {
+	buf := make([]byte, sock.MTU())
 
-	t := time.Now().UTC()
+	for {
+		n, err := sock.Read(buf)
+		if err != nil {
+			debug("CharacteristicSocket.Read() failed, %v", err)
+			return
+		}
 
-	if b.tempPath != s.Path {
+		data := make([]byte, n)
+		copy(data, buf)
+
+		b.handleTemperatureUpdate(data, time.Now().UTC())
+	}
+}
+
+// stopTemperature stops the temperature notifications.
+func (b *Bbq) stopTemperature() error {
+	b.sockMut.Lock()
+	sock := b.tempSock
+	b.tempSock = nil
+	b.sockMut.Unlock()
+
+	if sock != nil {
+		return sock.Close()
+	}
+
+	return b.tempChar.StopNotify()
+}
+
+// acquireControl acquires a socket for the control writes if the
+// characteristic can be written without response, the writes go through
+// WriteValue otherwise.
+func (b *Bbq) acquireControl() {
+	flags, err := b.control.Flags()
+	if err != nil || !containsString(flags, "write-without-response") {
 		return
 	}
 
-	if s.Name != "org.freedesktop.DBus.Properties.PropertiesChanged" {
+	sock, err := b.control.AcquireWrite(context.Background())
+	if err != nil {
+		debug("AcquireWrite() failed, falling back to WriteValue(), %v", err)
 		return
 	}
 
-	// Properties changed should have a 3 element payload
-	if len(s.Body) != 3 {
+	b.sockMut.Lock()
+	b.controlSock = sock
+	b.sockMut.Unlock()
+}
+
+// writeControl writes a command to the control characteristic.
+func (b *Bbq) writeControl(payload []byte) error {
+	b.sockMut.Lock()
+	sock := b.controlSock
+	b.sockMut.Unlock()
+
+	if sock != nil {
+		_, err := sock.Write(payload)
+		return err
+	}
+
+	return b.control.WriteValue(payload, nil)
+}
+
+func (b *Bbq) handleTemperatureUpdate(data []byte, t time.Time) {
+	b.closeMut.RLock()
+	defer b.closeMut.RUnlock()
+
+	if b.closed {
 		return
 	}
 
-	// The first element is the name of the interface who's property
-	// changed
-	iface, ok := s.Body[0].(string)
-	if !ok {
+	// Only push out the changes if it won't block us, the socket reader
+	// and the Value watcher may both be delivering
+	select {
+	case b.events <- b.newMeasurement(data, t):
+	default:
+	}
+}
+
+func (b *Bbq) handleSettingsResult(data []byte, t time.Time) {
+	e, err := decodeSettingsResult(data, t)
+	if err != nil {
+		log.Print("Failed to decode settings result, ", err)
 		return
 	}
 
-	if iface != "org.bluez.GattCharacteristic1" {
+	b.closeMut.RLock()
+	defer b.closeMut.RUnlock()
+
+	if b.closed {
+		return
+	}
+
+	select {
+	case b.settings <- e:
+	default:
+		log.Printf("Dropped settings result %v", e)
+	}
+}
+
+func (b *Bbq) handleHistory(data []byte, t time.Time) {
+	b.historyMut.Lock()
+	requested, since := b.historyRequested, b.historySince
+	b.historyMut.Unlock()
+
+	if requested.IsZero() {
+		log.Print("Ignoring unrequested history frame")
 		return
 	}
 
-	// The second element is a dictionary mapping the names of the
-	// properties that have changed to their new values
-	props, ok := s.Body[1].(map[string]dbus.Variant)
-	if !ok {
+	m, err := decodeHistory(data, requested)
+	if err != nil {
+		log.Print("Failed to decode history frame, ", err)
 		return
 	}
 
-	// This is the temperature property
-	raw, ok := props["Value"]
-	if !ok {
+	// The device may send more than was asked for, only keep what fills
+	// the gap
+	if !m.T.After(since) {
 		return
 	}
 
-	data, ok := raw.Value().([]uint8)
-	if !ok {
+	b.closeMut.RLock()
+	defer b.closeMut.RUnlock()
+
+	if b.closed {
 		return
 	}
 
-	// Only push out the changes if it won't block us
-	if len(b.events) == 0 {
-		b.events <- b.newMeasurement(data, t)
+	select {
+	case b.history <- m:
+	default:
+		log.Printf("Dropped history sample at %v", m.T)
 	}
 }
 
 func (b *Bbq) newMeasurement(data []uint8, t time.Time) Measurement {
-	temps := make([]int16, 6)
-	for i := 0; i < 6; i++ {
-		l, h := data[2*i], data[2*i+1]
+	// Each probe is reported as two bytes, so the number of probes is
+	// given by the length of the payload. A trailing odd byte is ignored.
+	temps := make([]Reading, len(data)/2)
+	for i := range temps {
+		temps[i] = decodeReading(data[2*i:])
+	}
 
-		if h == 0 {
-			temps[i] = int16(l)
-			continue
-		}
+	return Measurement{Temperatures: temps, T: t}
+}
 
-		temps[i] = math.MinInt16
+// decodeReading decodes a little endian, signed 16 bit temperature in tenths
+// of a degree Celsius. The device always reports Celsius, whatever unit it
+// displays.
+func decodeReading(data []uint8) Reading {
+	raw := binary.LittleEndian.Uint16(data)
+	if raw == rawUnplugged {
+		return Reading{Unplugged: true}
 	}
 
-	return Measurement{temps, t}
+	return Reading{Value: Temperature(float64(int16(raw)) / 10)}
 }
 
 func (b *Bbq) Measurements() chan Measurement {
 	return b.events
 }
 
+// RestartNotifications stops and restarts the temperature notifications,
+// which revives them when BlueZ has silently stopped delivering them.
+func (b *Bbq) RestartNotifications() error {
+	if err := b.stopTemperature(); err != nil {
+		debug("stopTemperature() failed, %v", err)
+	}
+
+	return b.startTemperature()
+}
+
+// Settings returns the decoded settings results reported by the device.
+func (b *Bbq) Settings() chan SettingsEvent {
+	return b.settings
+}
+
+// RequestBattery asks the device to report its battery level, the result is
+// delivered as a BatteryEvent on Settings().
+func (b *Bbq) RequestBattery() error {
+	return b.writeControl([]byte{0x08, settingsBattery, 0x00, 0x00, 0x00, 0x00})
+}
+
+// RequestVersion asks the device to report its firmware version, the result
+// is delivered as a VersionEvent on Settings().
+func (b *Bbq) RequestVersion() error {
+	return b.writeControl([]byte{0x08, settingsVersion, 0x00, 0x00, 0x00, 0x00})
+}
+
+// History returns the samples downloaded from the device history buffer,
+// stamped with the time they were taken.
+func (b *Bbq) History() chan Measurement {
+	return b.history
+}
+
+// RequestHistory asks the device for the samples it has buffered since the
+// given time, they are delivered on History().
+func (b *Bbq) RequestHistory(since time.Time) error {
+	now := time.Now().UTC()
+
+	b.historyMut.Lock()
+	b.historyRequested, b.historySince = now, since
+	b.historyMut.Unlock()
+
+	return b.writeControl(historyRequest(historySamples(since, now)))
+}
+
+// SetProbeTarget programs the alarm range of probe, counting from 0, into the
+// device. The device sounds its alarm on its own whenever the probe
+// temperature leaves [low, high].
+func (b *Bbq) SetProbeTarget(probe int, low, high Temperature) error {
+	if probe < 0 || probe > 0xff {
+		return fmt.Errorf("%w: %d", ErrInvalidProbe, probe)
+	}
+
+	l, err := encodeTemperature(low)
+	if err != nil {
+		return err
+	}
+
+	h, err := encodeTemperature(high)
+	if err != nil {
+		return err
+	}
+
+	if l > h {
+		return fmt.Errorf("%w: low %.1f is above high %.1f", ErrInvalidTarget, low, high)
+	}
+
+	payload := []byte{0x01, byte(probe), 0x00, 0x00, 0x00, 0x00}
+	binary.LittleEndian.PutUint16(payload[2:], uint16(l))
+	binary.LittleEndian.PutUint16(payload[4:], uint16(h))
+
+	return b.writeControl(payload)
+}
+
+// SetUnit sets the unit the device displays. It doesn't affect the unit of
+// the reported temperatures. The device acknowledges with a UnitEvent on
+// Settings().
+func (b *Bbq) SetUnit(u Unit) error {
+	payload := []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x00}
+	if u == Fahrenheit {
+		payload[1] = 0x01
+	}
+
+	return b.writeControl(payload)
+}
+
+// SilenceAlarm silences an alarm currently sounding on the device.
+func (b *Bbq) SilenceAlarm() error {
+	return b.writeControl([]byte{0x04, 0xff, 0x00, 0x00, 0x00, 0x00})
+}
+
+// encodeTemperature is the inverse of decodeReading.
+func encodeTemperature(c Temperature) (int16, error) {
+	tenths := math.Round(float64(c) * 10)
+	if tenths < math.MinInt16 || tenths > math.MaxInt16 {
+		return 0, fmt.Errorf("%w: %.1f", ErrInvalidTarget, c)
+	}
+
+	// The value reserved for unplugged probes can't be used as a target
+	if uint16(int16(tenths)) == rawUnplugged {
+		return 0, fmt.Errorf("%w: %.1f", ErrInvalidTarget, c)
+	}
+
+	return int16(tenths), nil
+}
+
 func (b *Bbq) Close() error {
-	close(b.events)
+	b.closeMut.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.events)
+		close(b.settings)
+		close(b.history)
+	}
+	b.closeMut.Unlock()
+
+	b.teardown()
 
 	return b.dev.Disconnect(context.Background())
 }
 
-func (b *Bbq) SignalMatchers() []*SignalMatcher {
-	return b.matchers
+// teardown stops watching the characteristic values and closes the sockets,
+// which also ends readTemperature.
+func (b *Bbq) teardown() {
+	b.stopWatch()
+
+	b.sockMut.Lock()
+	for _, sock := range []*CharacteristicSocket{b.tempSock, b.controlSock} {
+		if sock != nil {
+			sock.Close()
+		}
+	}
+	b.tempSock, b.controlSock = nil, nil
+	b.sockMut.Unlock()
 }
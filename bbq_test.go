@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecodeReading(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want Reading
+	}{
+		{"zero", []byte{0x00, 0x00}, Reading{Value: 0}},
+		{"positive", []byte{0xe5, 0x00}, Reading{Value: 22.9}},
+		{"negative", []byte{0x9c, 0xff}, Reading{Value: -10}},
+		{"high", []byte{0x10, 0x27}, Reading{Value: 1000}},
+		{"unplugged", []byte{0xf6, 0xff}, Reading{Unplugged: true}},
+		{"trailing bytes", []byte{0x0a, 0x00, 0xff}, Reading{Value: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeReading(tt.data); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeTemperature(t *testing.T) {
+	tests := []struct {
+		name string
+		c    Temperature
+		want int16
+		err  error
+	}{
+		{"zero", 0, 0, nil},
+		{"positive", 22.9, 229, nil},
+		{"rounded", 22.96, 230, nil},
+		{"negative", -10, -100, nil},
+		{"unplugged value", -1, 0, ErrInvalidTarget},
+		{"too high", 3276.8, 0, ErrInvalidTarget},
+		{"too low", -3276.9, 0, ErrInvalidTarget},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := encodeTemperature(tt.c)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeTemperatureRoundTrip(t *testing.T) {
+	for _, c := range []Temperature{-40, -0.5, 0.1, 63.5, 250} {
+		raw, err := encodeTemperature(c)
+		if err != nil {
+			t.Fatalf("encodeTemperature(%v) failed, %v", c, err)
+		}
+
+		data := []byte{byte(uint16(raw)), byte(uint16(raw) >> 8)}
+		if got := decodeReading(data); got.Unplugged || got.Value != c {
+			t.Errorf("round trip of %v got %+v", c, got)
+		}
+	}
+}
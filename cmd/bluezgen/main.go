@@ -0,0 +1,467 @@
+// Command bluezgen generates typed bindings for BlueZ D-Bus interfaces from
+// their introspection XML. For every interface it emits, as methods of the
+// Go type named after the interface (org.bluez.GattService1 is bound to
+// GattService), a method per D-Bus method and a getter, plus a setter if the
+// property is writable, per property. The Go type must embed
+// DBusObjectProxy.
+//
+// Members are steered with annotations:
+//
+//	org.bbq.Go.Name       Go name of an interface or member
+//	org.bbq.Go.Type       Go type of a property, e.g. time.Duration for
+//	                      timeouts in seconds, or map[uint16][]byte
+//	org.bbq.Go.Skip       leave the member out, it's bound by hand
+//	org.bbq.Go.NoContext  the method doesn't take a context
+//
+// Usage:
+//
+//	bluezgen [-o file] [-package name] file.xml|dir ...
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+type (
+	node struct {
+		Interfaces []iface `xml:"interface"`
+	}
+
+	iface struct {
+		Name        string       `xml:"name,attr"`
+		Methods     []method     `xml:"method"`
+		Properties  []property   `xml:"property"`
+		Annotations []annotation `xml:"annotation"`
+	}
+
+	method struct {
+		Name        string       `xml:"name,attr"`
+		Args        []arg        `xml:"arg"`
+		Annotations []annotation `xml:"annotation"`
+	}
+
+	arg struct {
+		Name      string `xml:"name,attr"`
+		Type      string `xml:"type,attr"`
+		Direction string `xml:"direction,attr"`
+	}
+
+	property struct {
+		Name        string       `xml:"name,attr"`
+		Type        string       `xml:"type,attr"`
+		Access      string       `xml:"access,attr"`
+		Annotations []annotation `xml:"annotation"`
+	}
+
+	annotation struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:"value,attr"`
+	}
+
+	generator struct {
+		buf     bytes.Buffer
+		imports map[string]bool
+	}
+)
+
+const (
+	annotationName      = "org.bbq.Go.Name"
+	annotationType      = "org.bbq.Go.Type"
+	annotationSkip      = "org.bbq.Go.Skip"
+	annotationNoContext = "org.bbq.Go.NoContext"
+)
+
+var errUnsupported = errors.New("unsupported signature")
+
+var basicTypes = map[byte]string{
+	'y': "byte",
+	'b': "bool",
+	'n': "int16",
+	'q': "uint16",
+	'i': "int32",
+	'u': "uint32",
+	'x': "int64",
+	't': "uint64",
+	'd': "float64",
+	's': "string",
+	'o': "dbus.ObjectPath",
+	'g': "dbus.Signature",
+	'h': "dbus.UnixFD",
+	'v': "dbus.Variant",
+}
+
+var keywords = map[string]bool{
+	"break": true, "case": true, "chan": true, "const": true, "continue": true,
+	"default": true, "defer": true, "else": true, "fallthrough": true, "for": true,
+	"func": true, "go": true, "goto": true, "if": true, "import": true,
+	"interface": true, "map": true, "package": true, "range": true, "return": true,
+	"select": true, "struct": true, "switch": true, "type": true, "var": true,
+}
+
+func main() {
+	out := flag.String("o", "", "output file, standard output if empty")
+	pkg := flag.String("package", "main", "package of the generated code")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		log.Fatal("no introspection XML given")
+	}
+
+	files, err := xmlFiles(flag.Args())
+	if err != nil {
+		log.Fatal("xmlFiles() failed, ", err)
+	}
+
+	g := &generator{imports: make(map[string]bool)}
+
+	for _, f := range files {
+		blob, err := ioutil.ReadFile(f)
+		if err != nil {
+			log.Fatal("ReadFile() failed, ", err)
+		}
+
+		var n node
+		if err := xml.Unmarshal(blob, &n); err != nil {
+			log.Fatalf("failed to parse %s: %v", f, err)
+		}
+
+		for _, i := range n.Interfaces {
+			if err := g.genInterface(i); err != nil {
+				log.Fatalf("%s: %v", f, err)
+			}
+		}
+	}
+
+	src, err := format.Source(g.file(*pkg))
+	if err != nil {
+		log.Fatal("format.Source() failed, ", err)
+	}
+
+	if *out == "" {
+		os.Stdout.Write(src)
+		return
+	}
+
+	if err := ioutil.WriteFile(*out, src, 0644); err != nil {
+		log.Fatal("WriteFile() failed, ", err)
+	}
+}
+
+// xmlFiles expands directories to the XML files they hold.
+func xmlFiles(args []string) ([]string, error) {
+	var files []string
+
+	for _, a := range args {
+		fi, err := os.Stat(a)
+		if err != nil {
+			return nil, err
+		}
+
+		if !fi.IsDir() {
+			files = append(files, a)
+			continue
+		}
+
+		matches, err := filepath.Glob(filepath.Join(a, "*.xml"))
+		if err != nil {
+			return nil, err
+		}
+
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+
+	return files, nil
+}
+
+func (g *generator) file(pkg string) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "// Code generated by bluezgen. DO NOT EDIT.\n\npackage %s\n\n", pkg)
+
+	if len(g.imports) != 0 {
+		imports := make([]string, 0, len(g.imports))
+		for i := range g.imports {
+			imports = append(imports, i)
+		}
+		sort.Strings(imports)
+
+		b.WriteString("import (\n")
+		for _, i := range imports {
+			if i == "github.com/godbus/dbus/v5" {
+				fmt.Fprintf(&b, "\tdbus %q\n", i)
+			} else {
+				fmt.Fprintf(&b, "\t%q\n", i)
+			}
+		}
+		b.WriteString(")\n")
+	}
+
+	b.Write(g.buf.Bytes())
+
+	return b.Bytes()
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+func (g *generator) genInterface(i iface) error {
+	typ := lookup(i.Annotations, annotationName)
+	if typ == "" {
+		typ = strings.TrimRightFunc(i.Name[strings.LastIndex(i.Name, ".")+1:], unicode.IsDigit)
+	}
+
+	recv := receiver(typ)
+
+	g.printf("\n// %s binds %s.\n", typ, i.Name)
+
+	for _, m := range i.Methods {
+		if lookup(m.Annotations, annotationSkip) == "true" {
+			continue
+		}
+
+		if err := g.genMethod(i.Name, typ, recv, m); err != nil {
+			return fmt.Errorf("%s.%s: %w", i.Name, m.Name, err)
+		}
+	}
+
+	for _, p := range i.Properties {
+		if lookup(p.Annotations, annotationSkip) == "true" {
+			continue
+		}
+
+		if err := g.genProperty(i.Name, typ, recv, p); err != nil {
+			return fmt.Errorf("%s.%s: %w", i.Name, p.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (g *generator) genMethod(ifaceName, typ, recv string, m method) error {
+	name := lookup(m.Annotations, annotationName)
+	if name == "" {
+		name = m.Name
+	}
+
+	noContext := lookup(m.Annotations, annotationNoContext) == "true"
+
+	var params, callArgs, outs, outVars []string
+	if !noContext {
+		g.imports["context"] = true
+		params = append(params, "ctx context.Context")
+	}
+
+	for n, a := range m.Args {
+		t, err := goType(a.Type, false)
+		if err != nil {
+			return err
+		}
+
+		if strings.Contains(t, "dbus.") {
+			g.imports["github.com/godbus/dbus/v5"] = true
+		}
+
+		if a.Direction == "out" {
+			v := argName(a.Name, fmt.Sprintf("out%d", n))
+			outs = append(outs, t)
+			outVars = append(outVars, v)
+			continue
+		}
+
+		v := argName(a.Name, fmt.Sprintf("arg%d", n))
+		params = append(params, v+" "+t)
+		callArgs = append(callArgs, v)
+	}
+
+	results := "error"
+	if len(outs) != 0 {
+		results = "(" + strings.Join(append(outs, "error"), ", ") + ")"
+	}
+
+	g.printf("\n// %s calls %s.%s.\n", name, ifaceName, m.Name)
+	g.printf("func (%s *%s) %s(%s) %s {\n", recv, typ, name, strings.Join(params, ", "), results)
+	g.printf("debug(%q)\n\n", fmt.Sprintf("%s.%s()", typ, name))
+
+	call := fmt.Sprintf("%s.Call(%q, 0", recv, ifaceName+"."+m.Name)
+	if !noContext {
+		call = fmt.Sprintf("%s.CallWithContext(ctx, %q, 0", recv, ifaceName+"."+m.Name)
+	}
+	for _, a := range callArgs {
+		call += ", " + a
+	}
+	call += ")"
+
+	if len(outs) == 0 {
+		g.printf("return %s.Store()\n}\n", call)
+		return nil
+	}
+
+	refs := make([]string, len(outVars))
+	for n, v := range outVars {
+		g.printf("var %s %s\n", v, outs[n])
+		refs[n] = "&" + v
+	}
+
+	g.printf("err := %s.Store(%s)\n\n", call, strings.Join(refs, ", "))
+	g.printf("return %s, err\n}\n", strings.Join(outVars, ", "))
+
+	return nil
+}
+
+func (g *generator) genProperty(ifaceName, typ, recv string, p property) error {
+	name := lookup(p.Annotations, annotationName)
+	if name == "" {
+		name = p.Name
+	}
+
+	t := lookup(p.Annotations, annotationType)
+	if t == "" {
+		var err error
+		if t, err = goType(p.Type, true); err != nil {
+			return err
+		}
+	}
+
+	if strings.Contains(t, "dbus.") {
+		g.imports["github.com/godbus/dbus/v5"] = true
+	}
+	if strings.Contains(t, "time.") {
+		g.imports["time"] = true
+	}
+
+	g.printf("\n// %s reads the %s.%s property.\n", name, ifaceName, p.Name)
+	g.printf("func (%s *%s) %s() (%s, error) {\n", recv, typ, name, t)
+	g.printf("var v %s\n", t)
+	g.printf("return v, %s.decodeProperty(%q, &v)\n}\n", recv, p.Name)
+
+	if p.Access != "readwrite" && p.Access != "write" {
+		return nil
+	}
+
+	// Durations are decoded from, and so written as, seconds
+	value := "v"
+	if t == "time.Duration" {
+		value = "uint32(v / time.Second)"
+	}
+
+	g.printf("\n// Set%s writes the %s.%s property.\n", name, ifaceName, p.Name)
+	g.printf("func (%s *%s) Set%s(v %s) error {\n", recv, typ, name, t)
+	g.printf("return %s.SetProperty(%q, %s)\n}\n", recv, p.Name, value)
+
+	return nil
+}
+
+// goType returns the Go type of the D-Bus signature sig. Object paths of
+// properties are returned as strings as the proxy decodes them so.
+func goType(sig string, prop bool) (string, error) {
+	t, rest, err := parseType(sig, prop)
+	if err != nil {
+		return "", err
+	}
+
+	if rest != "" {
+		return "", fmt.Errorf("%w: %s holds more than one type", errUnsupported, sig)
+	}
+
+	return t, nil
+}
+
+func parseType(sig string, prop bool) (string, string, error) {
+	if sig == "" {
+		return "", "", fmt.Errorf("%w: empty", errUnsupported)
+	}
+
+	c := sig[0]
+
+	if c == 'o' && prop {
+		return "string", sig[1:], nil
+	}
+
+	if t, ok := basicTypes[c]; ok {
+		return t, sig[1:], nil
+	}
+
+	if c != 'a' || len(sig) < 2 {
+		return "", "", fmt.Errorf("%w: %s", errUnsupported, sig)
+	}
+
+	if sig[1] != '{' {
+		elem, rest, err := parseType(sig[1:], prop)
+		if err != nil {
+			return "", "", err
+		}
+
+		return "[]" + elem, rest, nil
+	}
+
+	key, rest, err := parseType(sig[2:], prop)
+	if err != nil {
+		return "", "", err
+	}
+
+	value, rest, err := parseType(rest, prop)
+	if err != nil {
+		return "", "", err
+	}
+
+	if rest == "" || rest[0] != '}' {
+		return "", "", fmt.Errorf("%w: %s", errUnsupported, sig)
+	}
+
+	return "map[" + key + "]" + value, rest[1:], nil
+}
+
+func lookup(annotations []annotation, name string) string {
+	for _, a := range annotations {
+		if a.Name == name {
+			return a.Value
+		}
+	}
+
+	return ""
+}
+
+// receiver names the receiver after the last word of the type, e.g. c for
+// GattCharacteristic.
+func receiver(typ string) string {
+	for i := len(typ) - 1; i >= 0; i-- {
+		if unicode.IsUpper(rune(typ[i])) {
+			return strings.ToLower(typ[i : i+1])
+		}
+	}
+
+	return "x"
+}
+
+// argName turns a D-Bus argument name, e.g. UUID, into a Go identifier.
+func argName(name, fallback string) string {
+	if name == "" {
+		return fallback
+	}
+
+	n := []rune(name)
+	for i := 0; i < len(n) && unicode.IsUpper(n[i]); i++ {
+		n[i] = unicode.ToLower(n[i])
+	}
+
+	s := string(n)
+	if keywords[s] || s == "ctx" || s == "err" || s == "v" {
+		return s + "_"
+	}
+
+	return s
+}
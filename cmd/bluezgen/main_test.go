@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGoType(t *testing.T) {
+	tests := []struct {
+		sig  string
+		prop bool
+		want string
+	}{
+		{"s", false, "string"},
+		{"n", false, "int16"},
+		{"q", false, "uint16"},
+		{"h", false, "dbus.UnixFD"},
+		{"o", false, "dbus.ObjectPath"},
+		{"o", true, "string"},
+		{"ay", false, "[]byte"},
+		{"as", false, "[]string"},
+		{"ao", true, "[]string"},
+		{"aay", false, "[][]byte"},
+		{"a{sv}", false, "map[string]dbus.Variant"},
+		{"a{qv}", true, "map[uint16]dbus.Variant"},
+		{"a{oa{sv}}", false, "map[dbus.ObjectPath]map[string]dbus.Variant"},
+		{"a{sas}", false, "map[string][]string"},
+	}
+
+	for _, tt := range tests {
+		got, err := goType(tt.sig, tt.prop)
+		if err != nil {
+			t.Errorf("goType(%q, %v) failed, %v", tt.sig, tt.prop, err)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("goType(%q, %v) got %q, want %q", tt.sig, tt.prop, got, tt.want)
+		}
+	}
+}
+
+func TestGoTypeUnsupported(t *testing.T) {
+	for _, sig := range []string{"", "a", "(ss)", "ss", "a{sv", "a{s}", "z"} {
+		if _, err := goType(sig, false); !errors.Is(err, errUnsupported) {
+			t.Errorf("goType(%q) got %v, want errUnsupported", sig, err)
+		}
+	}
+}
+
+func TestParseTypeRest(t *testing.T) {
+	typ, rest, err := parseType("a{sv}as", false)
+	if err != nil {
+		t.Fatalf("parseType() failed, %v", err)
+	}
+
+	if typ != "map[string]dbus.Variant" || rest != "as" {
+		t.Errorf("got %q, %q", typ, rest)
+	}
+}
+
+func TestReceiver(t *testing.T) {
+	tests := map[string]string{
+		"GattCharacteristic": "c",
+		"Device":             "d",
+		"Adapter":            "a",
+		"lower":              "x",
+	}
+
+	for typ, want := range tests {
+		if got := receiver(typ); got != want {
+			t.Errorf("receiver(%q) got %q, want %q", typ, got, want)
+		}
+	}
+}
+
+func TestArgName(t *testing.T) {
+	tests := []struct {
+		name     string
+		fallback string
+		want     string
+	}{
+		{"UUID", "arg0", "uuid"},
+		{"Address", "arg0", "address"},
+		{"addressType", "arg0", "addressType"},
+		{"", "arg1", "arg1"},
+		{"Type", "arg0", "type_"},
+		{"v", "arg0", "v_"},
+	}
+
+	for _, tt := range tests {
+		if got := argName(tt.name, tt.fallback); got != tt.want {
+			t.Errorf("argName(%q) got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
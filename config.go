@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	Config struct {
+		Adapter  AdapterConfig  `yaml:"adapter"`
+		Device   DeviceConfig   `yaml:"device"`
+		InfluxDB InfluxDBConfig `yaml:"influxdb"`
+		Web      WebConfig      `yaml:"web"`
+	}
+
+	// AdapterConfig chooses the adapter by path, address or name, every
+	// one given must match. With none given the first adapter is used.
+	AdapterConfig struct {
+		Path    string `yaml:"path"`
+		Address string `yaml:"address"`
+
+		// Name matches either the name or the alias of the adapter
+		Name string `yaml:"name"`
+
+		// Failover uses another adapter while the chosen one is
+		// missing, e.g. unplugged or being reset
+		Failover bool `yaml:"failover"`
+	}
+
+	DeviceConfig struct {
+		// Name selects devices by name, it's ignored if Select is
+		// given
+		Name string `yaml:"name"`
+
+		// Select selects the devices matching any of the selectors
+		Select DeviceSelectors `yaml:"select"`
+
+		// Key is the hex encoded credentials written to the iBBQ
+		// account and verify characteristic
+		Key string `yaml:"key"`
+
+		// Unit is the unit shown on the device display
+		Unit string `yaml:"unit"`
+
+		// StaleAfter is how long a device may go without sending a
+		// sample before it is considered stale, 0 disables the check
+		StaleAfter string `yaml:"stale_after"`
+	}
+
+	InfluxDBConfig struct {
+		Addr     string `yaml:"addr"`
+		Database string `yaml:"database"`
+		Cut      string `yaml:"cut"`
+
+		// Unit is the unit temperatures are written in
+		Unit string `yaml:"unit"`
+	}
+
+	WebConfig struct {
+		Addr string `yaml:"addr"`
+	}
+
+	// selectorsFlag collects repeated -select flags.
+	selectorsFlag DeviceSelectors
+)
+
+const envPrefix = "BBQ_"
+
+var ErrConfigInvalid = errors.New("invalid configuration")
+
+func DefaultConfig() *Config {
+	return &Config{
+		Device: DeviceConfig{
+			Name:       "BBQ",
+			Key:        "2107060504030201b8220000000000",
+			Unit:       "C",
+			StaleAfter: "30s",
+		},
+		InfluxDB: InfluxDBConfig{
+			Addr:     "localhost:8086",
+			Database: "bbq",
+			Cut:      "brisket",
+			Unit:     "C",
+		},
+		Web: WebConfig{
+			Addr: ":9000",
+		},
+	}
+}
+
+// LoadConfig builds the configuration from, in increasing order of
+// precedence, the built in defaults, the YAML file named by -config (or
+// BBQ_CONFIG), BBQ_* environment variables and command line flags. The
+// configuration flags are registered on fs, which may already hold flags of
+// its own, before args are parsed.
+func LoadConfig(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg := DefaultConfig()
+
+	path := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "path to a YAML configuration file")
+
+	// The flag values are only applied if they were explicitly given so
+	// that they don't mask values from the file or the environment.
+	adapter := fs.String("adapter", "", "D-Bus object path of the Bluetooth adapter")
+	adapterAddress := fs.String("adapter-address", "", "address of the Bluetooth adapter")
+	adapterName := fs.String("adapter-name", "", "name or alias of the Bluetooth adapter")
+	adapterFailover := fs.Bool("adapter-failover", false, "use another adapter while the chosen one is missing")
+	device := fs.String("device", "", "name advertised by the thermometer")
+	var selectors selectorsFlag
+	fs.Var(&selectors, "select", "device selector, e.g. address=AA:BB:CC:DD:EE:FF,uuid=fff0, may be repeated")
+	deviceKey := fs.String("device-key", "", "hex encoded iBBQ login credentials")
+	deviceUnit := fs.String("device-unit", "", "unit shown on the device display, C or F")
+	staleAfter := fs.String("stale-after", "", "how long a device may go without sending a sample, 0 to disable")
+	influxAddr := fs.String("influxdb-addr", "", "InfluxDB address, host:port")
+	influxDatabase := fs.String("influxdb-database", "", "InfluxDB database")
+	cut := fs.String("cut", "", "value of the cut tag written to InfluxDB")
+	influxUnit := fs.String("influxdb-unit", "", "unit temperatures are written to InfluxDB in, C or F")
+	webAddr := fs.String("web-addr", "", "address the web server listens on")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *path != "" {
+		if err := cfg.loadFile(*path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "adapter":
+			cfg.Adapter.Path = *adapter
+		case "adapter-address":
+			cfg.Adapter.Address = *adapterAddress
+		case "adapter-name":
+			cfg.Adapter.Name = *adapterName
+		case "adapter-failover":
+			cfg.Adapter.Failover = *adapterFailover
+		case "device":
+			cfg.Device.Name = *device
+		case "select":
+			cfg.Device.Select = DeviceSelectors(selectors)
+		case "device-key":
+			cfg.Device.Key = *deviceKey
+		case "device-unit":
+			cfg.Device.Unit = *deviceUnit
+		case "stale-after":
+			cfg.Device.StaleAfter = *staleAfter
+		case "influxdb-addr":
+			cfg.InfluxDB.Addr = *influxAddr
+		case "influxdb-database":
+			cfg.InfluxDB.Database = *influxDatabase
+		case "cut":
+			cfg.InfluxDB.Cut = *cut
+		case "influxdb-unit":
+			cfg.InfluxDB.Unit = *influxUnit
+		case "web-addr":
+			cfg.Web.Addr = *webAddr
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if err := yaml.UnmarshalStrict(blob, c); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (c *Config) loadEnv() error {
+	vars := map[string]*string{
+		"ADAPTER":           &c.Adapter.Path,
+		"ADAPTER_ADDRESS":   &c.Adapter.Address,
+		"ADAPTER_NAME":      &c.Adapter.Name,
+		"DEVICE":            &c.Device.Name,
+		"DEVICE_KEY":        &c.Device.Key,
+		"DEVICE_UNIT":       &c.Device.Unit,
+		"STALE_AFTER":       &c.Device.StaleAfter,
+		"INFLUXDB_ADDR":     &c.InfluxDB.Addr,
+		"INFLUXDB_DATABASE": &c.InfluxDB.Database,
+		"CUT":               &c.InfluxDB.Cut,
+		"INFLUXDB_UNIT":     &c.InfluxDB.Unit,
+		"WEB_ADDR":          &c.Web.Addr,
+	}
+
+	for name, dst := range vars {
+		if v, ok := os.LookupEnv(envPrefix + name); ok {
+			*dst = v
+		}
+	}
+
+	if v, ok := os.LookupEnv(envPrefix + "ADAPTER_FAILOVER"); ok {
+		failover, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%sADAPTER_FAILOVER: %w", envPrefix, err)
+		}
+
+		c.Adapter.Failover = failover
+	}
+
+	// BBQ_SELECT holds selectors separated by semicolons
+	if v, ok := os.LookupEnv(envPrefix + "SELECT"); ok {
+		var selectors selectorsFlag
+		for _, s := range strings.Split(v, ";") {
+			if err := selectors.Set(s); err != nil {
+				return fmt.Errorf("%sSELECT: %w", envPrefix, err)
+			}
+		}
+
+		c.Device.Select = DeviceSelectors(selectors)
+	}
+
+	return nil
+}
+
+func (c *Config) Validate() error {
+	if c.Adapter.Path != "" && !strings.HasPrefix(c.Adapter.Path, "/") {
+		return fmt.Errorf("%w: adapter path %q is not an object path", ErrConfigInvalid, c.Adapter.Path)
+	}
+
+	if c.Device.Name == "" && len(c.Device.Select) == 0 {
+		return fmt.Errorf("%w: neither device name nor selectors given", ErrConfigInvalid)
+	}
+
+	for _, s := range c.Device.Select {
+		if err := s.Validate(); err != nil {
+			return fmt.Errorf("%w: %v", ErrConfigInvalid, err)
+		}
+	}
+
+	// iBBQ devices don't send anything until logged in
+	if c.Device.Key == "" {
+		return fmt.Errorf("%w: device key is empty", ErrConfigInvalid)
+	}
+
+	if _, err := c.Device.KeyBytes(); err != nil {
+		return fmt.Errorf("%w: device key: %v", ErrConfigInvalid, err)
+	}
+
+	if d, err := c.Device.StaleAfterDuration(); err != nil || d < 0 {
+		return fmt.Errorf("%w: stale after %q", ErrConfigInvalid, c.Device.StaleAfter)
+	}
+
+	if _, err := ParseUnit(c.Device.Unit); err != nil {
+		return fmt.Errorf("%w: device unit: %v", ErrConfigInvalid, err)
+	}
+
+	if _, err := ParseUnit(c.InfluxDB.Unit); err != nil {
+		return fmt.Errorf("%w: influxdb unit: %v", ErrConfigInvalid, err)
+	}
+
+	if c.InfluxDB.Addr == "" {
+		return fmt.Errorf("%w: influxdb address is empty", ErrConfigInvalid)
+	}
+
+	if c.InfluxDB.Database == "" {
+		return fmt.Errorf("%w: influxdb database is empty", ErrConfigInvalid)
+	}
+
+	if c.Web.Addr == "" {
+		return fmt.Errorf("%w: web address is empty", ErrConfigInvalid)
+	}
+
+	return nil
+}
+
+// Selectors returns the device selectors, falling back on selecting by
+// Name.
+func (c DeviceConfig) Selectors() DeviceSelectors {
+	if len(c.Select) != 0 {
+		return c.Select
+	}
+
+	return DeviceSelectors{{Name: c.Name}}
+}
+
+func (c DeviceConfig) KeyBytes() ([]byte, error) {
+	return hex.DecodeString(c.Key)
+}
+
+func (c DeviceConfig) StaleAfterDuration() (time.Duration, error) {
+	if c.StaleAfter == "" || c.StaleAfter == "0" {
+		return 0, nil
+	}
+
+	return time.ParseDuration(c.StaleAfter)
+}
+
+func (f *selectorsFlag) String() string {
+	if f == nil {
+		return ""
+	}
+
+	return fmt.Sprint(*f)
+}
+
+func (f *selectorsFlag) Set(value string) error {
+	s, err := ParseDeviceSelector(value)
+	if err != nil {
+		return err
+	}
+
+	*f = append(*f, s)
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadConfig(args []string) (*Config, error) {
+	return LoadConfig(flag.NewFlagSet("bbq", flag.ContinueOnError), args)
+}
+
+// setEnv sets the BBQ_* environment variables for the duration of the test,
+// those already set are cleared first.
+func setEnv(t *testing.T, vars map[string]string) {
+	for _, kv := range os.Environ() {
+		if name := strings.SplitN(kv, "=", 2)[0]; strings.HasPrefix(name, envPrefix) {
+			value := os.Getenv(name)
+			os.Unsetenv(name)
+			t.Cleanup(func() { os.Setenv(name, value) })
+		}
+	}
+
+	for name, value := range vars {
+		os.Setenv(name, value)
+		t.Cleanup(func() { os.Unsetenv(name) })
+	}
+}
+
+func writeConfig(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "bbq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "bbq.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+device:
+  name: FileBBQ
+influxdb:
+  database: file
+  cut: file
+web:
+  addr: ":1000"
+`)
+
+	setEnv(t, map[string]string{
+		"BBQ_CUT":      "env",
+		"BBQ_WEB_ADDR": ":2000",
+	})
+
+	cfg, err := loadConfig([]string{"-config", path, "-web-addr", ":3000"})
+	if err != nil {
+		t.Fatalf("LoadConfig() failed, %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"default", cfg.InfluxDB.Addr, "localhost:8086"},
+		{"file over default", cfg.Device.Name, "FileBBQ"},
+		{"file only", cfg.InfluxDB.Database, "file"},
+		{"environment over file", cfg.InfluxDB.Cut, "env"},
+		{"flag over environment", cfg.Web.Addr, ":3000"},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigConfigFromEnv(t *testing.T) {
+	path := writeConfig(t, "influxdb:\n  cut: ribs\n")
+	setEnv(t, map[string]string{"BBQ_CONFIG": path})
+
+	cfg, err := loadConfig(nil)
+	if err != nil {
+		t.Fatalf("LoadConfig() failed, %v", err)
+	}
+
+	if cfg.InfluxDB.Cut != "ribs" {
+		t.Errorf("got cut %q, want ribs", cfg.InfluxDB.Cut)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	setEnv(t, nil)
+
+	unknown := writeConfig(t, "influxdb:\n  colour: red\n")
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"unknown flag", []string{"-colour", "red"}},
+		{"missing file", []string{"-config", "/nonexistent/bbq.yaml"}},
+		{"unknown file key", []string{"-config", unknown}},
+		{"invalid value", []string{"-influxdb-addr", ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := loadConfig(tt.args); err == nil {
+				t.Fatal("got nil, want an error")
+			}
+		})
+	}
+}
+
+func TestLoadConfigOwnFlags(t *testing.T) {
+	setEnv(t, nil)
+
+	fs := flag.NewFlagSet("bbq scan", flag.ContinueOnError)
+	duration := fs.Duration("duration", time.Second, "")
+
+	cfg, err := LoadConfig(fs, []string{"-adapter-name", "hci1", "-duration", "5s"})
+	if err != nil {
+		t.Fatalf("LoadConfig() failed, %v", err)
+	}
+
+	if cfg.Adapter.Name != "hci1" || *duration != 5*time.Second {
+		t.Errorf("got adapter name %q and duration %v", cfg.Adapter.Name, *duration)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		ok     bool
+	}{
+		{"defaults", func(c *Config) {}, true},
+		{"relative adapter path", func(c *Config) { c.Adapter.Path = "hci0" }, false},
+		{"no device name", func(c *Config) { c.Device.Name = "" }, false},
+		{"no influxdb address", func(c *Config) { c.InfluxDB.Addr = "" }, false},
+		{"no influxdb database", func(c *Config) { c.InfluxDB.Database = "" }, false},
+		{"no web address", func(c *Config) { c.Web.Addr = "" }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.modify(cfg)
+
+			err := cfg.Validate()
+			if tt.ok && err != nil {
+				t.Fatalf("Validate() failed, %v", err)
+			}
+
+			if !tt.ok && !errors.Is(err, ErrConfigInvalid) {
+				t.Fatalf("got %v, want ErrConfigInvalid", err)
+			}
+		})
+	}
+}
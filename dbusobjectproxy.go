@@ -1,7 +1,15 @@
 package main
 
+//go:generate go run ./cmd/bluezgen -o bluez_generated.go introspect
+
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	dbus "github.com/godbus/dbus/v5"
@@ -12,91 +20,332 @@ type (
 		dbus.BusObject
 		conn  *dbus.Conn
 		iface string
+		cache *propertyCache
+	}
+
+	// propertyCache holds the values of the properties that never change
+	// during the lifetime of an object, such as UUIDs and parent paths,
+	// so that they're read from the bus at most once.
+	propertyCache struct {
+		mut    sync.RWMutex
+		keys   map[string]bool
+		values map[string]interface{}
+		loaded bool
 	}
 
 	Properties map[string]interface{}
+
+	// PropertyChange reports the properties of an object that changed,
+	// with their new values, and those that were invalidated.
+	PropertyChange struct {
+		Path        dbus.ObjectPath
+		Interface   string
+		Changed     map[string]dbus.Variant
+		Invalidated []string
+		T           time.Time
+	}
 )
 
-func newDBusObjectProxy(conn *dbus.Conn, dest, iface, path string) DBusObjectProxy {
+// newDBusObjectProxy returns a proxy for the object at path, the properties
+// listed in cached are cached once read.
+func newDBusObjectProxy(conn *dbus.Conn, dest, iface, path string, cached ...string) DBusObjectProxy {
+	keys := make(map[string]bool)
+	for _, k := range cached {
+		keys[k] = true
+	}
+
 	return DBusObjectProxy{
 		BusObject: conn.Object(dest, dbus.ObjectPath(path)),
 		conn:      conn,
 		iface:     iface,
+		cache: &propertyCache{
+			keys:   keys,
+			values: make(map[string]interface{}),
+		},
 	}
 }
 
-func (p DBusObjectProxy) propName(key string) string {
-	return strings.Join([]string{p.iface, key}, ".")
-}
+// Cache seeds the property cache from already known property values, such
+// as those returned by GetManagedObjects. Properties that aren't cacheable
+// are ignored.
+func (p DBusObjectProxy) Cache(attrs map[string]interface{}) {
+	p.cache.mut.Lock()
+	defer p.cache.mut.Unlock()
 
-func (p DBusObjectProxy) GetObjectPathProperty(key string) (dbus.ObjectPath, error) {
-	v, err := p.BusObject.GetProperty(p.propName(key))
-	if err != nil {
-		return "", err
+	for k, v := range attrs {
+		if p.cache.keys[k] {
+			p.cache.values[k] = v
+		}
 	}
 
-	return v.Value().(dbus.ObjectPath), nil
+	p.cache.loaded = true
 }
 
-func (p DBusObjectProxy) GetStringProperty(key string) (string, error) {
-	v, err := p.BusObject.GetProperty(p.propName(key))
-	if err != nil {
-		return "", err
+// Refresh reloads the cacheable properties with a single GetAll call.
+func (p DBusObjectProxy) Refresh() error {
+	props := make(map[string]dbus.Variant)
+	if err := p.Call("org.freedesktop.DBus.Properties.GetAll", 0, p.iface).Store(&props); err != nil {
+		return err
 	}
 
-	return v.Value().(string), nil
+	attrs := make(map[string]interface{})
+	for k, v := range props {
+		attrs[k] = v.Value()
+	}
+
+	p.Cache(attrs)
+
+	return nil
 }
 
-func (p DBusObjectProxy) GetStringSliceProperty(key string) ([]string, error) {
+// property returns the value of the property key. Cacheable properties are
+// served from the cache, which is loaded with Refresh the first time it's
+// needed, everything else is read from the bus.
+func (p DBusObjectProxy) property(key string) (interface{}, error) {
+	if p.cache.keys[key] {
+		p.cache.mut.RLock()
+		v, ok := p.cache.values[key]
+		loaded := p.cache.loaded
+		p.cache.mut.RUnlock()
+
+		if ok {
+			return v, nil
+		}
+
+		if !loaded {
+			if err := p.Refresh(); err != nil {
+				return nil, err
+			}
+
+			p.cache.mut.RLock()
+			v, ok = p.cache.values[key]
+			p.cache.mut.RUnlock()
+
+			if ok {
+				return v, nil
+			}
+		}
+	}
+
 	v, err := p.BusObject.GetProperty(p.propName(key))
 	if err != nil {
 		return nil, err
 	}
 
-	return v.Value().([]string), nil
+	return v.Value(), nil
 }
 
-func (p DBusObjectProxy) GetBoolProperty(key string) (bool, error) {
-	v, err := p.BusObject.GetProperty(p.propName(key))
+func (p DBusObjectProxy) propName(key string) string {
+	return strings.Join([]string{p.iface, key}, ".")
+}
+
+// decodeProperty reads the property key into dst, see decodeValue.
+func (p DBusObjectProxy) decodeProperty(key string, dst interface{}) error {
+	v, err := p.property(key)
 	if err != nil {
-		return false, err
+		var dbusErr dbus.Error
+		if errors.As(err, &dbusErr) && dbusErr.Name == "org.freedesktop.DBus.Error.InvalidArgs" {
+			return fmt.Errorf("%w: %s", ErrPropertyMissing, p.propName(key))
+		}
+
+		return err
 	}
 
-	return v.Value().(bool), nil
+	return decodeValue(p.propName(key), v, reflect.ValueOf(dst).Elem())
 }
 
-func (p DBusObjectProxy) GetDurationProperty(key string) (time.Duration, error) {
-	v, err := p.BusObject.GetProperty(p.propName(key))
-	if err != nil {
-		return time.Duration(0), err
+// GetAll reads every property of the proxied interface with a single call
+// and decodes them into the struct dst points to, see DecodeProperties.
+func (p DBusObjectProxy) GetAll(dst interface{}) error {
+	props := make(map[string]dbus.Variant)
+	if err := p.Call("org.freedesktop.DBus.Properties.GetAll", 0, p.iface).Store(&props); err != nil {
+		return err
 	}
 
-	return time.Duration(v.Value().(uint32)), nil
+	return DecodeProperties(props, dst)
+}
+
+func (p DBusObjectProxy) GetObjectPathProperty(key string) (dbus.ObjectPath, error) {
+	var v dbus.ObjectPath
+	return v, p.decodeProperty(key, &v)
+}
+
+func (p DBusObjectProxy) GetStringProperty(key string) (string, error) {
+	var v string
+	return v, p.decodeProperty(key, &v)
+}
+
+func (p DBusObjectProxy) GetStringSliceProperty(key string) ([]string, error) {
+	var v []string
+	return v, p.decodeProperty(key, &v)
+}
+
+func (p DBusObjectProxy) GetBoolProperty(key string) (bool, error) {
+	var v bool
+	return v, p.decodeProperty(key, &v)
+}
+
+// GetDurationProperty reads a timeout, which BlueZ gives in seconds.
+func (p DBusObjectProxy) GetDurationProperty(key string) (time.Duration, error) {
+	var v time.Duration
+	return v, p.decodeProperty(key, &v)
 }
 
 func (p DBusObjectProxy) GetUint32Property(key string) (uint32, error) {
-	v, err := p.BusObject.GetProperty(p.propName(key))
+	var v uint32
+	return v, p.decodeProperty(key, &v)
+}
+
+func (p DBusObjectProxy) GetUint16Property(key string) (uint16, error) {
+	var v uint16
+	return v, p.decodeProperty(key, &v)
+}
+
+func (p DBusObjectProxy) GetInt16Property(key string) (int16, error) {
+	var v int16
+	return v, p.decodeProperty(key, &v)
+}
+
+func (p DBusObjectProxy) GetByteSliceProperty(key string) ([]byte, error) {
+	var v []byte
+	return v, p.decodeProperty(key, &v)
+}
+
+// SetProperty sets the property key of the proxied interface.
+func (p DBusObjectProxy) SetProperty(key string, v interface{}) error {
+	debug("DBusObjectProxy.SetProperty(%v, %v)", p.propName(key), v)
+
+	return p.BusObject.SetProperty(p.propName(key), dbus.MakeVariant(v))
+}
+
+// WatchProperties reports the property changes of the proxied interface
+// until ctx is done, when the returned channel is closed. If names are
+// given only those properties are reported, and changes that touch none of
+// them are left out.
+func (p DBusObjectProxy) WatchProperties(ctx context.Context, names ...string) (<-chan PropertyChange, error) {
+	wanted := make(map[string]bool)
+	for _, n := range names {
+		wanted[n] = true
+	}
+
+	ch := make(chan PropertyChange, 16)
+	done := make(chan struct{})
+
+	rt, err := Router(p.conn).Register(SignalRule{
+		Path:      p.Path(),
+		Interface: "org.freedesktop.DBus.Properties",
+		Member:    "PropertiesChanged",
+		Arg0:      p.iface,
+	}, func(s *dbus.Signal) {
+		c, ok := newPropertyChange(s, wanted)
+		if !ok {
+			return
+		}
+
+		select {
+		case ch <- c:
+		case <-done:
+		}
+	})
 	if err != nil {
-		return uint32(0), err
+		return nil, err
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		// Closing done unblocks the handler, nothing is sent once it
+		// has returned for the last time
+		close(done)
+		rt.Close()
+		<-rt.Done()
+
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+// newPropertyChange decodes a PropertiesChanged signal keeping only the
+// wanted properties, all of them if wanted is empty. It reports false if
+// the signal is malformed or no wanted property is left.
+func newPropertyChange(s *dbus.Signal, wanted map[string]bool) (PropertyChange, bool) {
+	if len(s.Body) != 3 {
+		return PropertyChange{}, false
+	}
+
+	c := PropertyChange{
+		Path:    s.Path,
+		Changed: make(map[string]dbus.Variant),
+		T:       time.Now().UTC(),
+	}
+
+	c.Interface, _ = s.Body[0].(string)
+	changed, _ := s.Body[1].(map[string]dbus.Variant)
+	invalidated, _ := s.Body[2].([]string)
+
+	for k, v := range changed {
+		if len(wanted) == 0 || wanted[k] {
+			c.Changed[k] = v
+		}
+	}
+
+	for _, k := range invalidated {
+		if len(wanted) == 0 || wanted[k] {
+			c.Invalidated = append(c.Invalidated, k)
+		}
+	}
+
+	if len(c.Changed) == 0 && len(c.Invalidated) == 0 {
+		return PropertyChange{}, false
 	}
 
-	return v.Value().(uint32), nil
+	return c, true
 }
 
-func (p DBusObjectProxy) GetUint16Property(key string) (uint16, error) {
-	v, err := p.BusObject.GetProperty(p.propName(key))
-	if err != nil {
-		return uint16(0), err
+// Value decodes the new value of the property name into dst, see
+// decodeValue. It reports false, and leaves dst alone, if the property
+// didn't change.
+func (c PropertyChange) Value(name string, dst interface{}) (bool, error) {
+	v, ok := c.Changed[name]
+	if !ok {
+		return false, nil
 	}
 
-	return v.Value().(uint16), nil
+	return true, decodeValue(name, v, reflect.ValueOf(dst).Elem())
 }
 
-func (p DBusObjectProxy) GetByteSliceProperty(key string) ([]byte, error) {
-	v, err := p.BusObject.GetProperty(p.propName(key))
+// Decode decodes the changed properties into the struct dst points to, see
+// DecodeProperties.
+func (c PropertyChange) Decode(dst interface{}) error {
+	return DecodeProperties(c.Changed, dst)
+}
+
+// watchDecoded decodes every property change of the object into the struct
+// newChanged returns a pointer to and hands both to send, until ctx is done
+// or send returns false. A property that fails to decode is left nil, the
+// others are still reported. done is called once it stops.
+func (p DBusObjectProxy) watchDecoded(ctx context.Context, newChanged func() interface{},
+	send func(c PropertyChange, changed interface{}) bool, done func()) error {
+	changes, err := p.WatchProperties(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return v.Value().([]byte), nil
+	go func() {
+		defer done()
+
+		for c := range changes {
+			changed := newChanged()
+			if err := c.Decode(changed); err != nil {
+				log.Print("DecodeProperties() failed, ", err)
+			}
+
+			if !send(c, changed) {
+				return
+			}
+		}
+	}()
+
+	return nil
 }
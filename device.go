@@ -3,6 +3,9 @@ package main
 import (
 	"context"
 	"errors"
+	"reflect"
+	"sync"
+	"time"
 
 	dbus "github.com/godbus/dbus/v5"
 )
@@ -11,8 +14,48 @@ type (
 	Device struct {
 		DBusObjectProxy
 
+		// mut protects Services, which is updated as BlueZ adds and
+		// removes GATT objects
+		mut      sync.RWMutex
 		Services map[string]*GattService
 	}
+
+	// DeviceProperties holds Device1 properties, nil fields are unknown.
+	DeviceProperties struct {
+		Address          *string
+		AddressType      *string
+		Name             *string
+		Icon             *string
+		Class            *uint32
+		Appearance       *uint16
+		UUIDs            []string
+		Paired           *bool
+		Bonded           *bool
+		Connected        *bool
+		Trusted          *bool
+		Blocked          *bool
+		WakeAllowed      *bool
+		Alias            *string
+		Adapter          *string
+		LegacyPairing    *bool
+		Modalias         *string
+		RSSI             *int16
+		TxPower          *int16
+		ManufacturerData map[uint16][]byte
+		ServiceData      map[string][]byte
+		ServicesResolved *bool
+		AdvertisingFlags []byte
+		AdvertisingData  map[byte][]byte
+	}
+
+	// DeviceChange reports the properties of a device that changed, and
+	// those that were invalidated.
+	DeviceChange struct {
+		Path        string
+		Changed     DeviceProperties
+		Invalidated []string
+		T           time.Time
+	}
 )
 
 var ErrServiceNotFound = errors.New("service not found")
@@ -21,7 +64,7 @@ func NewDevice(conn *dbus.Conn, path string) *Device {
 	debug("NewDevice(%v, %v)", conn, path)
 
 	return &Device{
-		DBusObjectProxy: newDBusObjectProxy(conn, destOrgBluez, "org.bluez.Device1", path),
+		DBusObjectProxy: newDBusObjectProxy(conn, destOrgBluez, "org.bluez.Device1", path, "Address", "AddressType", "Adapter"),
 		Services:        make(map[string]*GattService),
 	}
 }
@@ -32,56 +75,81 @@ func (d *Device) attachService(s *GattService) error {
 		return nil
 	}
 
+	d.mut.Lock()
 	d.Services[uuid] = s
+	d.mut.Unlock()
 
 	return nil
 }
 
-func (d *Device) Disconnect(ctx context.Context) error {
-	debug("Device.Disconnect()")
+func (d *Device) detachService(s *GattService) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
 
-	return d.CallWithContext(ctx, "org.bluez.Device1.Disconnect", 0).Store()
+	for uuid, x := range d.Services {
+		if x == s {
+			delete(d.Services, uuid)
+		}
+	}
 }
 
-func (d *Device) Connect(ctx context.Context) error {
-	debug("Device.Connect()")
+func (d *Device) setServices(services map[string]*GattService) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
 
-	return d.CallWithContext(ctx, "org.bluez.Device1.Connect", 0).Store()
+	d.Services = services
 }
 
 func (d *Device) DisconnectProfile(ctx context.Context, uuid string) error {
-	debug("Device.DisconnectProfile()")
+	debug("Device.DisconnectProfile(%v)", uuid)
 
-	return d.CallWithContext(ctx, "org.bluez.Device1.DisconnectProfile", 0).Store()
+	return d.CallWithContext(ctx, "org.bluez.Device1.DisconnectProfile", 0, fullUUID(uuid)).Store()
 }
 
 func (d *Device) ConnectProfile(ctx context.Context, uuid string) error {
-	debug("Device.ConnectProfile()")
+	debug("Device.ConnectProfile(%v)", uuid)
 
-	return d.CallWithContext(ctx, "org.bluez.Device1.ConnectProfile", 0).Store()
+	return d.CallWithContext(ctx, "org.bluez.Device1.ConnectProfile", 0, fullUUID(uuid)).Store()
 }
 
-func (d *Device) Pair(ctx context.Context) error {
-	debug("Device.Pair()")
-
-	return d.CallWithContext(ctx, "org.bluez.Device1.Pair", 0).Store()
+// Properties reads every property of the device with a single call.
+func (d *Device) Properties() (DeviceProperties, error) {
+	var p DeviceProperties
+	return p, d.GetAll(&p)
 }
 
-func (d *Device) CancelPairing(ctx context.Context) error {
-	debug("Device.CancelPairing()")
+// Watch reports the property changes of the device until ctx is done.
+func (d *Device) Watch(ctx context.Context) (<-chan DeviceChange, error) {
+	ch := make(chan DeviceChange)
 
-	return d.CallWithContext(ctx, "org.bluez.Device1.CancelPairing", 0).Store()
-}
+	newChanged := func() interface{} { return &DeviceProperties{} }
+	send := func(c PropertyChange, changed interface{}) bool {
+		e := DeviceChange{
+			Path:        string(c.Path),
+			Changed:     *changed.(*DeviceProperties),
+			Invalidated: c.Invalidated,
+			T:           c.T,
+		}
 
-func (d *Device) Address() (string, error) {
-	return d.GetStringProperty("Address")
-}
+		select {
+		case ch <- e:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	if err := d.watchDecoded(ctx, newChanged, send, func() { close(ch) }); err != nil {
+		return nil, err
+	}
 
-func (d *Device) AddressType() (string, error) {
-	return d.GetStringProperty("AddressType")
+	return ch, nil
 }
 
 func (d *Device) Service(uuid string) (*GattService, error) {
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+
 	s, ok := d.Services[uuid]
 	if !ok {
 		return nil, ErrServiceNotFound
@@ -91,6 +159,9 @@ func (d *Device) Service(uuid string) (*GattService, error) {
 }
 
 func (d *Device) Characteristic(uuid string) (*GattCharacteristic, error) {
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+
 	for _, s := range d.Services {
 		if c, err := s.Characteristic(uuid); err == nil {
 			return c, nil
@@ -101,6 +172,9 @@ func (d *Device) Characteristic(uuid string) (*GattCharacteristic, error) {
 }
 
 func (d *Device) Descriptor(uuid string) (*GattDescriptor, error) {
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+
 	for _, s := range d.Services {
 		if d, err := s.Descriptor(uuid); err == nil {
 			return d, nil
@@ -110,110 +184,22 @@ func (d *Device) Descriptor(uuid string) (*GattDescriptor, error) {
 	return nil, ErrDescriptorNotFound
 }
 
-func (d *Device) Name() (string, error) {
-	return d.GetStringProperty("Name")
-}
-
-func (d *Device) Icon() (string, error) {
-	return d.GetStringProperty("Icon")
-}
-
-func (d *Device) Class() (uint32, error) {
-	return d.GetUint32Property("Icon")
-}
-
-func (d *Device) Appearance() (uint32, error) {
-	return d.GetUint32Property("Appearance")
-}
-
-func (d *Device) UUIDS() ([]string, error) {
-	v, err := d.GetProperty("Appearance")
-	if err != nil {
-		return nil, err
-	}
-
-	return v.Value().([]string), nil
-}
-
-func (d *Device) Paried() (bool, error) {
-	return d.GetBoolProperty("Paried")
-}
-
-func (d *Device) Connected() (bool, error) {
-	return d.GetBoolProperty("Connected")
-}
-
-func (d *Device) Trusted() (bool, error) {
-	return d.GetBoolProperty("Trusted")
-}
-
-func (d *Device) Blocked() (bool, error) {
-	return d.GetBoolProperty("Blocked")
-}
-
-func (d *Device) Alias() (string, error) {
-	return d.GetStringProperty("Alias")
-}
-
 func (d *Device) Adapter() (*Adapter, error) {
-	v, err := d.GetProperty("Adapter")
-	if err != nil {
-		return nil, err
-	}
-
-	return NewAdapter(d.conn, v.Value().(string)), nil
-}
-
-func (d *Device) LegacyPairing() (bool, error) {
-	return d.GetBoolProperty("LegacyPairing")
-}
-
-func (d *Device) Modalias() (string, error) {
-	return d.GetStringProperty("Modalias")
-}
-
-func (d *Device) RSSI() (uint16, error) {
-	return d.GetUint16Property("RSSI")
-}
-
-func (d *Device) TxPower() (uint16, error) {
-	return d.GetUint16Property("TxPower")
-}
-
-func (d *Device) ManufacturerData() (map[uint16][]byte, error) {
-	v, err := d.GetProperty("ManufacturerData")
+	path, err := d.GetObjectPathProperty("Adapter")
 	if err != nil {
 		return nil, err
 	}
 
-	data := make(map[uint16][]byte)
-
-	for k, v := range v.Value().(map[uint16]dbus.Variant) {
-		data[k] = v.Value().([]byte)
-	}
-
-	return data, nil
+	return NewAdapter(d.conn, string(path)), nil
 }
 
-func (d *Device) ServiceData() (map[string][]byte, error) {
-	v, err := d.GetProperty("ServiceData")
-	if err != nil {
-		return nil, err
-	}
-
-	data := make(map[string][]byte)
-
-	for k, v := range v.Value().(map[string]dbus.Variant) {
-		data[k] = v.Value().([]byte)
+// serviceData unwraps the ServiceData property value, it's nil if the value
+// can't be decoded.
+func serviceData(v interface{}) map[string][]byte {
+	var data map[string][]byte
+	if err := decodeValue("ServiceData", v, reflect.ValueOf(&data).Elem()); err != nil {
+		debug("serviceData() failed, %v", err)
 	}
 
-	return data, nil
-}
-
-func (d *Device) ServicesResolved() (bool, error) {
-	return d.GetBoolProperty("ServicesResolved")
-}
-
-func (d *Device) AdvertisingFlags() ([]byte, error) {
-	return d.GetByteSliceProperty("AdvertisingFlags")
+	return data
 }
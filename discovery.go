@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	dbus "github.com/godbus/dbus/v5"
+)
+
+const (
+	DeviceFound DiscoveryEventType = iota
+	DeviceUpdated
+	DeviceLost
+)
+
+type (
+	DiscoveryEventType int
+
+	// DiscoveryEvent reports a device found, updated or lost during a
+	// discovery session. Fields that weren't part of the advertisement
+	// are left empty.
+	DiscoveryEvent struct {
+		Type             DiscoveryEventType
+		Path             string
+		Address          string            `json:",omitempty"`
+		Name             string            `json:",omitempty"`
+		Alias            string            `json:",omitempty"`
+		RSSI             *int16            `json:",omitempty"`
+		TxPower          *int16            `json:",omitempty"`
+		UUIDs            []string          `json:",omitempty"`
+		ManufacturerData map[uint16][]byte `json:",omitempty"`
+		ServiceData      map[string][]byte `json:",omitempty"`
+		T                time.Time
+	}
+
+	DiscoveryOptions struct {
+		// Filter, if not nil, is the filter of the session, merged
+		// with those of the other sessions on the adapter
+		Filter *DiscoveryFilter
+
+		// Duration bounds the session, 0 means until the context is
+		// done
+		Duration time.Duration
+	}
+
+	// Scanner runs discovery sessions.
+	Scanner interface {
+		Discover(ctx context.Context, opts DiscoveryOptions) (<-chan DiscoveryEvent, error)
+	}
+
+	// sharedDiscovery is the BlueZ discovery of an adapter, shared by the
+	// discovery sessions running on it as BlueZ allows a single one per
+	// D-Bus connection. It's started by the first session and stopped
+	// once the last one is done, its filter is the merged filter of the
+	// sessions.
+	sharedDiscovery struct {
+		sessions map[*DiscoveryFilter]bool
+		filter   DiscoveryFilter
+	}
+
+	discoveryKey struct {
+		conn *dbus.Conn
+		path dbus.ObjectPath
+	}
+)
+
+var (
+	discoveriesMut sync.Mutex
+	discoveries    = make(map[discoveryKey]*sharedDiscovery)
+)
+
+func (t DiscoveryEventType) String() string {
+	switch t {
+	case DeviceFound:
+		return "found"
+	case DeviceUpdated:
+		return "updated"
+	case DeviceLost:
+		return "lost"
+	}
+
+	return "unknown"
+}
+
+func (t DiscoveryEventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// Discover runs a discovery session on the adapter. Devices under the adapter
+// are reported on the returned channel as they are found, as their
+// advertisement data changes and when BlueZ drops them. Discovery is
+// stopped, and the channel closed, when ctx is done or opts.Duration has
+// passed. Sessions running at the same time share the discovery of the
+// adapter, so that a session may also be reported devices only the filter
+// of another one lets through.
+func (a *Adapter) Discover(ctx context.Context, opts DiscoveryOptions) (<-chan DiscoveryEvent, error) {
+	debug("Adapter.Discover(ctx, %v)", opts)
+
+	ns := a.Path()
+	rules := []SignalRule{
+		{
+			Sender:    destOrgBluez,
+			Interface: "org.freedesktop.DBus.ObjectManager",
+		},
+		{
+			Sender:        destOrgBluez,
+			PathNamespace: ns,
+			Interface:     "org.freedesktop.DBus.Properties",
+			Member:        "PropertiesChanged",
+			Arg0:          ifaceDevice1,
+		},
+	}
+
+	// The routes forward to sigch until done is closed
+	sigch := make(chan *dbus.Signal, 64)
+	done := make(chan struct{})
+	forward := func(s *dbus.Signal) {
+		select {
+		case sigch <- s:
+		case <-done:
+		}
+	}
+
+	var routes []*SignalRoute
+	removeMatch := func() {
+		close(done)
+		for _, rt := range routes {
+			rt.Close()
+		}
+	}
+
+	for _, rule := range rules {
+		rt, err := Router(a.conn).Register(rule, forward)
+		if err != nil {
+			removeMatch()
+			return nil, err
+		}
+
+		routes = append(routes, rt)
+	}
+
+	// Devices BlueZ already knows of are reported as found the first time
+	// they're heard from during the session
+	known := make(map[string]map[string]interface{})
+	objs, err := NewObjectManager(a.conn, "/").GetManagedObjects()
+	if err != nil {
+		removeMatch()
+		return nil, err
+	}
+
+	for path, ifaces := range objs {
+		if attrs, ok := ifaces[ifaceDevice1]; ok && strings.HasPrefix(path, string(ns)+"/") {
+			known[path] = attrs
+		}
+	}
+
+	session := new(DiscoveryFilter)
+	if opts.Filter != nil {
+		*session = *opts.Filter
+	}
+
+	if err := a.joinDiscovery(ctx, session); err != nil {
+		removeMatch()
+		return nil, err
+	}
+
+	events := make(chan DiscoveryEvent, 16)
+
+	go func() {
+		defer close(events)
+		defer removeMatch()
+		defer a.leaveDiscovery(session)
+
+		// A nil channel never fires, which leaves the session unbounded
+		var timeout <-chan time.Time
+		if opts.Duration > 0 {
+			t := time.NewTimer(opts.Duration)
+			defer t.Stop()
+
+			timeout = t.C
+		}
+
+		seen := make(map[string]bool)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-timeout:
+				return
+
+			case s := <-sigch:
+				e, ok := discoveryEvent(s, string(ns), known, seen)
+				if !ok {
+					continue
+				}
+
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return
+				case <-timeout:
+					return
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// joinDiscovery adds the session, identified by its filter, to the discovery
+// of the adapter, starting it for the first session and updating the merged
+// filter for the others.
+func (a *Adapter) joinDiscovery(ctx context.Context, session *DiscoveryFilter) error {
+	discoveriesMut.Lock()
+	defer discoveriesMut.Unlock()
+
+	key := discoveryKey{a.conn, a.Path()}
+
+	d, ok := discoveries[key]
+	if !ok {
+		d = &sharedDiscovery{sessions: make(map[*DiscoveryFilter]bool)}
+	}
+
+	d.sessions[session] = true
+
+	if err := d.update(a, !ok); err != nil {
+		delete(d.sessions, session)
+		return err
+	}
+
+	if !ok {
+		if err := a.StartDiscovery(ctx); err != nil {
+			return err
+		}
+
+		discoveries[key] = d
+	}
+
+	return nil
+}
+
+// leaveDiscovery removes the session from the discovery of the adapter,
+// stopping it after the last session and otherwise loosening the merged
+// filter to what the remaining sessions need.
+func (a *Adapter) leaveDiscovery(session *DiscoveryFilter) {
+	discoveriesMut.Lock()
+	defer discoveriesMut.Unlock()
+
+	key := discoveryKey{a.conn, a.Path()}
+
+	d, ok := discoveries[key]
+	if !ok {
+		return
+	}
+
+	delete(d.sessions, session)
+
+	if len(d.sessions) != 0 {
+		if err := d.update(a, false); err != nil {
+			log.Print("SetDiscoveryFilter() failed, ", err)
+		}
+
+		return
+	}
+
+	delete(discoveries, key)
+
+	// The session context is done, so stop with a fresh one
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := a.StopDiscovery(ctx); err != nil {
+		log.Print("StopDiscovery() failed, ", err)
+	}
+}
+
+// update sets the merged filter of the sessions if it changed, or always if
+// force is set. discoveriesMut must be held.
+func (d *sharedDiscovery) update(a *Adapter, force bool) error {
+	filters := make([]DiscoveryFilter, 0, len(d.sessions))
+	for f := range d.sessions {
+		filters = append(filters, *f)
+	}
+
+	merged := mergeDiscoveryFilters(filters)
+	if !force && reflect.DeepEqual(merged, d.filter) {
+		return nil
+	}
+
+	if err := a.SetDiscoveryFilter(merged); err != nil {
+		return err
+	}
+
+	d.filter = merged
+
+	return nil
+}
+
+// discoveryEvent turns an object manager or property change signal for a
+// device under the adapter into a discovery event.
+func discoveryEvent(s *dbus.Signal, ns string, known map[string]map[string]interface{}, seen map[string]bool) (DiscoveryEvent, bool) {
+	t := time.Now().UTC()
+
+	switch s.Name {
+	case "org.freedesktop.DBus.ObjectManager.InterfacesAdded":
+		if len(s.Body) != 2 {
+			return DiscoveryEvent{}, false
+		}
+
+		path, _ := s.Body[0].(dbus.ObjectPath)
+		ifaces, _ := s.Body[1].(map[string]map[string]dbus.Variant)
+
+		props, ok := ifaces[ifaceDevice1]
+		if !ok || !strings.HasPrefix(string(path), ns+"/") {
+			return DiscoveryEvent{}, false
+		}
+
+		attrs := make(map[string]interface{})
+		for k, v := range props {
+			attrs[k] = v.Value()
+		}
+
+		known[string(path)] = attrs
+		seen[string(path)] = true
+
+		return newDiscoveryEvent(DeviceFound, string(path), attrs, t), true
+
+	case "org.freedesktop.DBus.ObjectManager.InterfacesRemoved":
+		if len(s.Body) != 2 {
+			return DiscoveryEvent{}, false
+		}
+
+		path, _ := s.Body[0].(dbus.ObjectPath)
+		ifaces, _ := s.Body[1].([]string)
+
+		attrs, ok := known[string(path)]
+		if !ok {
+			return DiscoveryEvent{}, false
+		}
+
+		for _, iface := range ifaces {
+			if iface == ifaceDevice1 {
+				delete(known, string(path))
+				delete(seen, string(path))
+
+				return newDiscoveryEvent(DeviceLost, string(path), attrs, t), true
+			}
+		}
+
+	case "org.freedesktop.DBus.Properties.PropertiesChanged":
+		if len(s.Body) != 3 {
+			return DiscoveryEvent{}, false
+		}
+
+		if iface, _ := s.Body[0].(string); iface != ifaceDevice1 {
+			return DiscoveryEvent{}, false
+		}
+
+		changed, _ := s.Body[1].(map[string]dbus.Variant)
+
+		attrs, ok := known[string(s.Path)]
+		if !ok {
+			return DiscoveryEvent{}, false
+		}
+
+		for k, v := range changed {
+			attrs[k] = v.Value()
+		}
+
+		typ := DeviceUpdated
+		if !seen[string(s.Path)] {
+			typ = DeviceFound
+			seen[string(s.Path)] = true
+		}
+
+		return newDiscoveryEvent(typ, string(s.Path), attrs, t), true
+	}
+
+	return DiscoveryEvent{}, false
+}
+
+func newDiscoveryEvent(typ DiscoveryEventType, path string, attrs map[string]interface{}, t time.Time) DiscoveryEvent {
+	e := DiscoveryEvent{
+		Type: typ,
+		Path: path,
+		T:    t,
+	}
+
+	e.Address, _ = attrs["Address"].(string)
+	e.Name, _ = attrs["Name"].(string)
+	e.Alias, _ = attrs["Alias"].(string)
+	e.UUIDs, _ = attrs["UUIDs"].([]string)
+
+	if rssi, ok := attrs["RSSI"].(int16); ok {
+		e.RSSI = &rssi
+	}
+
+	if tx, ok := attrs["TxPower"].(int16); ok {
+		e.TxPower = &tx
+	}
+
+	if v, ok := attrs["ManufacturerData"]; ok {
+		e.ManufacturerData = manufacturerData(v)
+	}
+
+	if v, ok := attrs["ServiceData"]; ok {
+		e.ServiceData = serviceData(v)
+	}
+
+	return e
+}
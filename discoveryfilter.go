@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	dbus "github.com/godbus/dbus/v5"
+)
+
+const (
+	TransportAuto  = "auto"
+	TransportBREDR = "bredr"
+	TransportLE    = "le"
+)
+
+type (
+	// DiscoveryFilter is the filter of Adapter.SetDiscoveryFilter. Unset
+	// fields, nil or empty, are left out and take the BlueZ defaults.
+	DiscoveryFilter struct {
+		// UUIDs only reports devices advertising any of the service
+		// UUIDs
+		UUIDs []string
+
+		// RSSI only reports devices received at least this strongly,
+		// it can't be combined with Pathloss
+		RSSI *int16
+
+		// Pathloss only reports devices whose path loss is at most
+		// this, it can't be combined with RSSI
+		Pathloss *uint16
+
+		// Transport is one of TransportAuto, TransportBREDR or
+		// TransportLE
+		Transport string
+
+		// DuplicateData reports every advertisement rather than only
+		// those that changed
+		DuplicateData *bool
+
+		// Discoverable only reports discoverable devices
+		Discoverable *bool
+
+		// Pattern only reports devices whose address or name starts
+		// with the pattern
+		Pattern string
+	}
+)
+
+var ErrInvalidDiscoveryFilter = errors.New("invalid discovery filter")
+
+// Validate checks the filter values, and if supported is not nil, that every
+// field set is one of the supported filters as returned by
+// Adapter.GetDiscoveryFilters.
+func (f DiscoveryFilter) Validate(supported []string) error {
+	if f.RSSI != nil && f.Pathloss != nil {
+		return fmt.Errorf("%w: RSSI and Pathloss are mutually exclusive", ErrInvalidDiscoveryFilter)
+	}
+
+	switch f.Transport {
+	case "", TransportAuto, TransportBREDR, TransportLE:
+	default:
+		return fmt.Errorf("%w: unknown transport %q", ErrInvalidDiscoveryFilter, f.Transport)
+	}
+
+	if supported == nil {
+		return nil
+	}
+
+	ok := make(map[string]bool)
+	for _, s := range supported {
+		ok[s] = true
+	}
+
+	for key := range f.Variants() {
+		if !ok[key] {
+			return fmt.Errorf("%w: %s not supported by adapter", ErrInvalidDiscoveryFilter, key)
+		}
+	}
+
+	return nil
+}
+
+// Variants returns the filter as the a{sv} dictionary SetDiscoveryFilter
+// takes.
+func (f DiscoveryFilter) Variants() map[string]dbus.Variant {
+	v := make(map[string]dbus.Variant)
+
+	if len(f.UUIDs) != 0 {
+		uuids := make([]string, len(f.UUIDs))
+		for i, u := range f.UUIDs {
+			uuids[i] = fullUUID(u)
+		}
+
+		v["UUIDs"] = dbus.MakeVariant(uuids)
+	}
+
+	if f.RSSI != nil {
+		v["RSSI"] = dbus.MakeVariant(*f.RSSI)
+	}
+
+	if f.Pathloss != nil {
+		v["Pathloss"] = dbus.MakeVariant(*f.Pathloss)
+	}
+
+	if f.Transport != "" {
+		v["Transport"] = dbus.MakeVariant(f.Transport)
+	}
+
+	if f.DuplicateData != nil {
+		v["DuplicateData"] = dbus.MakeVariant(*f.DuplicateData)
+	}
+
+	if f.Discoverable != nil {
+		v["Discoverable"] = dbus.MakeVariant(*f.Discoverable)
+	}
+
+	if f.Pattern != "" {
+		v["Pattern"] = dbus.MakeVariant(f.Pattern)
+	}
+
+	return v
+}
+
+// mergeDiscoveryFilters combines the filters of the discovery sessions
+// sharing an adapter into the loosest filter that still reports everything
+// each of them asks for, as BlueZ does for the sessions of different
+// clients.
+func mergeDiscoveryFilters(filters []DiscoveryFilter) DiscoveryFilter {
+	if len(filters) == 0 {
+		return DiscoveryFilter{}
+	}
+
+	merged := filters[0]
+	merged.UUIDs = nil
+
+	uuids := make(map[string]bool)
+	allUUIDs := false
+
+	for i, f := range filters {
+		if len(f.UUIDs) == 0 {
+			allUUIDs = true
+		}
+
+		for _, u := range f.UUIDs {
+			if u = fullUUID(u); !uuids[u] {
+				uuids[u] = true
+				merged.UUIDs = append(merged.UUIDs, u)
+			}
+		}
+
+		if i == 0 {
+			continue
+		}
+
+		switch {
+		case f.RSSI == nil || merged.RSSI == nil:
+			merged.RSSI = nil
+		case *f.RSSI < *merged.RSSI:
+			merged.RSSI = f.RSSI
+		}
+
+		switch {
+		case f.Pathloss == nil || merged.Pathloss == nil:
+			merged.Pathloss = nil
+		case *f.Pathloss > *merged.Pathloss:
+			merged.Pathloss = f.Pathloss
+		}
+
+		if f.Transport != merged.Transport {
+			merged.Transport = TransportAuto
+		}
+
+		if f.DuplicateData != nil && *f.DuplicateData {
+			merged.DuplicateData = f.DuplicateData
+		}
+
+		if f.Discoverable == nil || !*f.Discoverable {
+			merged.Discoverable = nil
+		}
+
+		if f.Pattern != merged.Pattern {
+			merged.Pattern = ""
+		}
+	}
+
+	if allUUIDs {
+		merged.UUIDs = nil
+	}
+
+	sort.Strings(merged.UUIDs)
+
+	return merged
+}
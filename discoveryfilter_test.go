@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDiscoveryFilterValidate(t *testing.T) {
+	rssi, pathloss, yes := int16(-80), uint16(60), true
+
+	tests := []struct {
+		name      string
+		f         DiscoveryFilter
+		supported []string
+		ok        bool
+	}{
+		{"empty", DiscoveryFilter{}, nil, true},
+		{"rssi", DiscoveryFilter{RSSI: &rssi}, nil, true},
+		{"pathloss", DiscoveryFilter{Pathloss: &pathloss}, nil, true},
+		{"rssi and pathloss", DiscoveryFilter{RSSI: &rssi, Pathloss: &pathloss}, nil, false},
+		{"le", DiscoveryFilter{Transport: TransportLE}, nil, true},
+		{"unknown transport", DiscoveryFilter{Transport: "usb"}, nil, false},
+		{
+			"supported",
+			DiscoveryFilter{UUIDs: []string{"fff0"}, Transport: TransportLE, DuplicateData: &yes},
+			[]string{"UUIDs", "RSSI", "Pathloss", "Transport", "DuplicateData"},
+			true,
+		},
+		{
+			"unsupported",
+			DiscoveryFilter{Discoverable: &yes},
+			[]string{"UUIDs", "RSSI", "Pathloss", "Transport", "DuplicateData"},
+			false,
+		},
+		{"nothing supported", DiscoveryFilter{Pattern: "AA"}, []string{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.f.Validate(tt.supported)
+			if tt.ok && err != nil {
+				t.Fatalf("Validate() failed, %v", err)
+			}
+
+			if !tt.ok && !errors.Is(err, ErrInvalidDiscoveryFilter) {
+				t.Fatalf("got %v, want ErrInvalidDiscoveryFilter", err)
+			}
+		})
+	}
+}
+
+func TestDiscoveryFilterVariants(t *testing.T) {
+	rssi := int16(-80)
+
+	v := DiscoveryFilter{
+		UUIDs:     []string{"fff0", "0000fff1-0000-1000-8000-00805f9b34fb"},
+		RSSI:      &rssi,
+		Transport: TransportLE,
+	}.Variants()
+
+	if len(v) != 3 {
+		t.Fatalf("got %d entries, want 3, %v", len(v), v)
+	}
+
+	uuids, _ := v["UUIDs"].Value().([]string)
+	if len(uuids) != 2 || uuids[0] != "0000fff0-0000-1000-8000-00805f9b34fb" {
+		t.Errorf("UUIDs got %v", uuids)
+	}
+
+	if got, _ := v["RSSI"].Value().(int16); got != rssi {
+		t.Errorf("RSSI got %v, want %d", v["RSSI"], rssi)
+	}
+}
+
+func TestMergeDiscoveryFilters(t *testing.T) {
+	low, high := int16(-90), int16(-60)
+	near, far := uint16(40), uint16(80)
+	yes, no := true, false
+
+	tests := []struct {
+		name    string
+		filters []DiscoveryFilter
+		want    DiscoveryFilter
+	}{
+		{"none", nil, DiscoveryFilter{}},
+		{
+			"single",
+			[]DiscoveryFilter{{UUIDs: []string{"fff0"}, RSSI: &high, Transport: TransportLE, Pattern: "AA"}},
+			DiscoveryFilter{UUIDs: []string{"0000fff0-0000-1000-8000-00805f9b34fb"}, RSSI: &high, Transport: TransportLE, Pattern: "AA"},
+		},
+		{
+			"uuid union",
+			[]DiscoveryFilter{{UUIDs: []string{"fff1"}}, {UUIDs: []string{"fff0", "0000fff1-0000-1000-8000-00805f9b34fb"}}},
+			DiscoveryFilter{UUIDs: []string{"0000fff0-0000-1000-8000-00805f9b34fb", "0000fff1-0000-1000-8000-00805f9b34fb"}},
+		},
+		{
+			"any uuid",
+			[]DiscoveryFilter{{UUIDs: []string{"fff0"}}, {}},
+			DiscoveryFilter{},
+		},
+		{
+			"weakest rssi",
+			[]DiscoveryFilter{{RSSI: &high}, {RSSI: &low}},
+			DiscoveryFilter{RSSI: &low},
+		},
+		{
+			"any rssi",
+			[]DiscoveryFilter{{RSSI: &high}, {}},
+			DiscoveryFilter{},
+		},
+		{
+			"largest pathloss",
+			[]DiscoveryFilter{{Pathloss: &near}, {Pathloss: &far}},
+			DiscoveryFilter{Pathloss: &far},
+		},
+		{
+			"rssi and pathloss",
+			[]DiscoveryFilter{{RSSI: &high}, {Pathloss: &near}},
+			DiscoveryFilter{},
+		},
+		{
+			"same transport",
+			[]DiscoveryFilter{{Transport: TransportLE}, {Transport: TransportLE}},
+			DiscoveryFilter{Transport: TransportLE},
+		},
+		{
+			"mixed transport",
+			[]DiscoveryFilter{{Transport: TransportLE}, {}},
+			DiscoveryFilter{Transport: TransportAuto},
+		},
+		{
+			"any duplicate data",
+			[]DiscoveryFilter{{DuplicateData: &no}, {DuplicateData: &yes}},
+			DiscoveryFilter{DuplicateData: &yes},
+		},
+		{
+			"discoverable only if all",
+			[]DiscoveryFilter{{Discoverable: &yes}, {Discoverable: &no}},
+			DiscoveryFilter{},
+		},
+		{
+			"different patterns",
+			[]DiscoveryFilter{{Pattern: "AA"}, {Pattern: "BB"}},
+			DiscoveryFilter{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeDiscoveryFilters(tt.filters)
+			if !reflect.DeepEqual(got.Variants(), tt.want.Variants()) {
+				t.Errorf("got %v, want %v", got.Variants(), tt.want.Variants())
+			}
+		})
+	}
+}
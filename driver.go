@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type (
+	// Thermometer is a connected thermometer of any brand.
+	Thermometer interface {
+		Measurements() chan Measurement
+		Close() error
+	}
+
+	// TargetController is implemented by thermometers that can be
+	// programmed with per probe alarm ranges.
+	TargetController interface {
+		SetProbeTarget(probe int, low, high Temperature) error
+		SilenceAlarm() error
+	}
+
+	// SettingsReporter is implemented by thermometers that report
+	// battery level, display unit and firmware version.
+	SettingsReporter interface {
+		Settings() chan SettingsEvent
+		RequestBattery() error
+		RequestVersion() error
+	}
+
+	// UnitSetter is implemented by thermometers with a configurable
+	// display unit.
+	UnitSetter interface {
+		SetUnit(u Unit) error
+	}
+
+	// NotificationRestarter is implemented by thermometers that can
+	// restart their notifications without reconnecting.
+	NotificationRestarter interface {
+		RestartNotifications() error
+	}
+
+	// HistoryReader is implemented by thermometers that buffer samples
+	// which can be downloaded after the fact.
+	HistoryReader interface {
+		History() chan Measurement
+		RequestHistory(since time.Time) error
+	}
+
+	// Driver knows how to recognise and connect to one kind of
+	// thermometer.
+	Driver interface {
+		Name() string
+
+		// Match reports whether the driver handles the device
+		Match(d *Device) bool
+
+		Connect(d *Device, cfg DeviceConfig) (Thermometer, error)
+	}
+
+	registry struct {
+		mut     sync.RWMutex
+		drivers []Driver
+	}
+)
+
+var ErrNoDriver = errors.New("no driver matches device")
+
+var drivers = &registry{}
+
+// RegisterDriver makes a driver available to DriverFor. Drivers are tried in
+// the order they are registered.
+func RegisterDriver(d Driver) {
+	drivers.mut.Lock()
+	defer drivers.mut.Unlock()
+
+	drivers.drivers = append(drivers.drivers, d)
+}
+
+// DriverFor returns the first registered driver that matches the device.
+func DriverFor(d *Device) (Driver, error) {
+	drivers.mut.RLock()
+	defer drivers.mut.RUnlock()
+
+	for _, drv := range drivers.drivers {
+		if drv.Match(d) {
+			return drv, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrNoDriver, d.Path())
+}
+
+// hasUUID reports whether the device advertises the service uuid.
+func hasUUID(d *Device, uuid string) bool {
+	uuids, err := d.UUIDS()
+	if err != nil {
+		return false
+	}
+
+	for _, u := range uuids {
+		if u == uuid {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
 
 	dbus "github.com/godbus/dbus/v5"
 )
@@ -10,17 +15,29 @@ type (
 	GattCharacteristic struct {
 		DBusObjectProxy
 
+		mut         sync.RWMutex
 		Descriptors map[string]*GattDescriptor
 	}
+
+	// CharacteristicSocket is a socket acquired with AcquireNotify or
+	// AcquireWrite. It carries one value per packet, of at most MTU
+	// bytes, without going through the bus.
+	CharacteristicSocket struct {
+		f   *os.File
+		mtu uint16
+	}
 )
 
-var ErrDescriptorNotFound = errors.New("descriptor not found")
+var (
+	ErrDescriptorNotFound = errors.New("descriptor not found")
+	ErrMTUExceeded        = errors.New("value exceeds MTU")
+)
 
 func NewGattCharacteristic(conn *dbus.Conn, path string) *GattCharacteristic {
 	debug("NewGattCharacteristic(%v, %v)", conn, path)
 
 	return &GattCharacteristic{
-		DBusObjectProxy: newDBusObjectProxy(conn, destOrgBluez, "org.bluez.GattCharacteristic1", path),
+		DBusObjectProxy: newDBusObjectProxy(conn, destOrgBluez, "org.bluez.GattCharacteristic1", path, "UUID", "Service", "Flags"),
 		Descriptors:     make(map[string]*GattDescriptor),
 	}
 }
@@ -31,67 +48,120 @@ func (c *GattCharacteristic) attachDescriptor(d *GattDescriptor) error {
 		return nil
 	}
 
+	c.mut.Lock()
 	c.Descriptors[uuid] = d
+	c.mut.Unlock()
 
 	return nil
 }
 
-func (c *GattCharacteristic) ReadValue() ([]byte, error) {
-	debug("GattCharacteristic.ReadValue()")
+func (c *GattCharacteristic) detachDescriptor(d *GattDescriptor) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	for uuid, x := range c.Descriptors {
+		if x == d {
+			delete(c.Descriptors, uuid)
+		}
+	}
+}
+
+// ReadValue reads the value of the characteristic, a nil opts reads it
+// from the start.
+func (c *GattCharacteristic) ReadValue(opts *ReadOptions) ([]byte, error) {
+	debug("GattCharacteristic.ReadValue(%+v)", opts)
 
 	blob := make([]byte, 0, 1024)
-	if err := c.Call("org.bluez.GattCharacteristic1.ReadValue", 0).Store(&blob); err != nil {
+	if err := c.Call("org.bluez.GattCharacteristic1.ReadValue", 0, opts.Variants()).Store(&blob); err != nil {
 		return nil, err
 	}
 
 	return blob, nil
 }
 
-func (c *GattCharacteristic) WriteValue(data []byte, options map[string]interface{}) error {
-	debug("GattCharacteristic.WriteValue()")
+// WriteValue writes the value of the characteristic, a nil opts takes the
+// BlueZ defaults.
+func (c *GattCharacteristic) WriteValue(data []byte, opts *WriteOptions) error {
+	debug("GattCharacteristic.WriteValue(%+v)", opts)
 
-	doptions := make(map[string]dbus.Variant)
-	return c.Call("org.bluez.GattCharacteristic1.WriteValue", 0, data, doptions).Store()
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	return c.Call("org.bluez.GattCharacteristic1.WriteValue", 0, data, opts.Variants()).Store()
 }
 
-func (c *GattCharacteristic) StartNotify() error {
-	debug("GattCharacteristic.StartNotify()")
+// AcquireNotify acquires a socket the notifications of the characteristic
+// are read from, instead of them being sent as PropertiesChanged signals.
+// Notifications stop when the socket is closed.
+func (c *GattCharacteristic) AcquireNotify(ctx context.Context) (*CharacteristicSocket, error) {
+	debug("GattCharacteristic.AcquireNotify()")
 
-	return c.Call("org.bluez.GattCharacteristic1.StartNotify", 0).Store()
+	return c.acquire(ctx, "org.bluez.GattCharacteristic1.AcquireNotify")
 }
 
-func (c *GattCharacteristic) StopNotify() error {
-	debug("GattCharacteristic.StopNotify()")
+// AcquireWrite acquires a socket values are written to without response.
+// BlueZ only offers it for characteristics flagged write-without-response.
+func (c *GattCharacteristic) AcquireWrite(ctx context.Context) (*CharacteristicSocket, error) {
+	debug("GattCharacteristic.AcquireWrite()")
 
-	return c.Call("org.bluez.GattCharacteristic1.StopNotfty", 0).Store()
+	return c.acquire(ctx, "org.bluez.GattCharacteristic1.AcquireWrite")
 }
 
-func (c *GattCharacteristic) Descriptor(uuid string) (*GattDescriptor, error) {
-	d, ok := c.Descriptors[uuid]
-	if !ok {
-		return nil, ErrDescriptorNotFound
+func (c *GattCharacteristic) acquire(ctx context.Context, method string) (*CharacteristicSocket, error) {
+	var fd dbus.UnixFD
+	var mtu uint16
+
+	options := make(map[string]dbus.Variant)
+	if err := c.CallWithContext(ctx, method, 0, options).Store(&fd, &mtu); err != nil {
+		return nil, err
 	}
 
-	return d, nil
+	// A non-blocking descriptor is handed to the runtime poller, so that
+	// Close interrupts a pending Read
+	if err := syscall.SetNonblock(int(fd), true); err != nil {
+		syscall.Close(int(fd))
+		return nil, err
+	}
+
+	return &CharacteristicSocket{
+		f:   os.NewFile(uintptr(fd), string(c.Path())),
+		mtu: mtu,
+	}, nil
 }
 
-func (c *GattCharacteristic) UUID() (string, error) {
-	return c.GetStringProperty("UUID")
+// MTU returns the largest value the socket carries.
+func (s *CharacteristicSocket) MTU() uint16 {
+	return s.mtu
 }
 
-func (c *GattCharacteristic) Service() (string, error) {
-	path, err := c.GetObjectPathProperty("Service")
-	if err != nil {
-		return "", err
+// Read reads one value, p should hold at least MTU bytes. io.EOF is returned
+// once BlueZ has closed the socket, e.g. because the device disconnected.
+func (s *CharacteristicSocket) Read(p []byte) (int, error) {
+	return s.f.Read(p)
+}
+
+// Write writes one value.
+func (s *CharacteristicSocket) Write(p []byte) (int, error) {
+	if len(p) > int(s.mtu) {
+		return 0, fmt.Errorf("%w: %d > %d", ErrMTUExceeded, len(p), s.mtu)
 	}
 
-	return string(path), nil
+	return s.f.Write(p)
 }
 
-func (c *GattCharacteristic) Notifying() (bool, error) {
-	return c.GetBoolProperty("Notifying")
+func (s *CharacteristicSocket) Close() error {
+	return s.f.Close()
 }
 
-func (c *GattCharacteristic) Flags() ([]string, error) {
-	return c.GetStringSliceProperty("Flags")
+func (c *GattCharacteristic) Descriptor(uuid string) (*GattDescriptor, error) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	d, ok := c.Descriptors[uuid]
+	if !ok {
+		return nil, ErrDescriptorNotFound
+	}
+
+	return d, nil
 }
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+
 	dbus "github.com/godbus/dbus/v5"
 )
 
@@ -14,44 +16,35 @@ func NewGattDescriptor(conn *dbus.Conn, path string) *GattDescriptor {
 	debug("NewGattDescriptor(%v, %v)", conn, path)
 
 	return &GattDescriptor{
-		DBusObjectProxy: newDBusObjectProxy(conn, destOrgBluez, "org.bluez.GattDescriptor1", path),
+		DBusObjectProxy: newDBusObjectProxy(conn, destOrgBluez, "org.bluez.GattDescriptor1", path, "UUID", "Characteristic", "Flags"),
 	}
 }
 
-func (d *GattDescriptor) ReadValue() ([]byte, error) {
-	debug("GattDescriptor.ReadValue()")
+// ReadValue reads the value of the descriptor, a nil opts reads it from the
+// start.
+func (d *GattDescriptor) ReadValue(opts *ReadOptions) ([]byte, error) {
+	debug("GattDescriptor.ReadValue(%+v)", opts)
 
 	blob := make([]byte, 0, 1024)
-	if err := d.Call("org.bluez.GattDescriptor1.ReadValue", 0).Store(&blob); err != nil {
+	if err := d.Call("org.bluez.GattDescriptor1.ReadValue", 0, opts.Variants()).Store(&blob); err != nil {
 		return nil, err
 	}
 
 	return blob, nil
 }
 
-func (d *GattDescriptor) WriteValue(data []byte) error {
-	debug("GattDescriptor.WriteValue()")
-
-	return d.Call("org.bluez.GattDescriptor1.WriteValue", 0, data).Store()
-}
-
-func (d *GattDescriptor) UUID() (string, error) {
-	return d.GetStringProperty("UUID")
-}
+// WriteValue writes the value of the descriptor, a nil opts takes the BlueZ
+// defaults.
+func (d *GattDescriptor) WriteValue(data []byte, opts *WriteOptions) error {
+	debug("GattDescriptor.WriteValue(%+v)", opts)
 
-func (d *GattDescriptor) Characteristic() (string, error) {
-	path, err := d.GetObjectPathProperty("Characteristic")
-	if err != nil {
-		return "", nil
+	if err := opts.Validate(); err != nil {
+		return err
 	}
 
-	return string(path), nil
-}
-
-func (d *GattDescriptor) Value() ([]byte, error) {
-	return d.GetByteSliceProperty("Value")
-}
+	if opts != nil && opts.Type != "" {
+		return fmt.Errorf("%w: descriptors have no write type", ErrInvalidWriteOptions)
+	}
 
-func (d *GattDescriptor) Flags() ([]string, error) {
-	return d.GetStringSliceProperty("Flags")
+	return d.Call("org.bluez.GattDescriptor1.WriteValue", 0, data, opts.Variants()).Store()
 }
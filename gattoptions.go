@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	dbus "github.com/godbus/dbus/v5"
+)
+
+const (
+	// WriteTypeCommand writes without response
+	WriteTypeCommand = "command"
+
+	// WriteTypeRequest writes with response
+	WriteTypeRequest = "request"
+
+	// WriteTypeReliable uses a reliable write
+	WriteTypeReliable = "reliable"
+)
+
+type (
+	// ReadOptions are the options of ReadValue, the zero value reads the
+	// value from the start.
+	ReadOptions struct {
+		// Offset reads a long value from this offset on
+		Offset uint16
+
+		// MTU is the exchanged MTU, BlueZ only uses it for local
+		// characteristics
+		MTU uint16
+	}
+
+	// WriteOptions are the options of WriteValue, zero values are left
+	// out and take the BlueZ defaults.
+	WriteOptions struct {
+		// Offset writes a long value from this offset on
+		Offset uint16
+
+		// Type is one of WriteTypeCommand, WriteTypeRequest or
+		// WriteTypeReliable, if empty BlueZ picks one from the flags
+		// of the characteristic. Descriptors have no write type.
+		Type string
+
+		// MTU is the exchanged MTU, BlueZ only uses it for local
+		// characteristics
+		MTU uint16
+
+		// PrepareAuthorize asks for the authorization of a prepared
+		// write, BlueZ only uses it for local characteristics
+		PrepareAuthorize bool
+	}
+)
+
+var ErrInvalidWriteOptions = errors.New("invalid write options")
+
+// Variants returns the options as the a{sv} dictionary ReadValue takes, a
+// nil o gives an empty dictionary.
+func (o *ReadOptions) Variants() map[string]dbus.Variant {
+	v := make(map[string]dbus.Variant)
+	if o == nil {
+		return v
+	}
+
+	if o.Offset != 0 {
+		v["offset"] = dbus.MakeVariant(o.Offset)
+	}
+
+	if o.MTU != 0 {
+		v["mtu"] = dbus.MakeVariant(o.MTU)
+	}
+
+	return v
+}
+
+// Validate checks the write type, and that commands aren't written at an
+// offset.
+func (o *WriteOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+
+	switch o.Type {
+	case "", WriteTypeCommand, WriteTypeRequest, WriteTypeReliable:
+	default:
+		return fmt.Errorf("%w: unknown write type %q", ErrInvalidWriteOptions, o.Type)
+	}
+
+	if o.Type == WriteTypeCommand && o.Offset != 0 {
+		return fmt.Errorf("%w: a command can't be written at an offset", ErrInvalidWriteOptions)
+	}
+
+	return nil
+}
+
+// Variants returns the options as the a{sv} dictionary WriteValue takes, a
+// nil o gives an empty dictionary.
+func (o *WriteOptions) Variants() map[string]dbus.Variant {
+	v := make(map[string]dbus.Variant)
+	if o == nil {
+		return v
+	}
+
+	if o.Offset != 0 {
+		v["offset"] = dbus.MakeVariant(o.Offset)
+	}
+
+	if o.Type != "" {
+		v["type"] = dbus.MakeVariant(o.Type)
+	}
+
+	if o.MTU != 0 {
+		v["mtu"] = dbus.MakeVariant(o.MTU)
+	}
+
+	if o.PrepareAuthorize {
+		v["prepare-authorize"] = dbus.MakeVariant(true)
+	}
+
+	return v
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	dbus "github.com/godbus/dbus/v5"
+)
+
+func TestWriteOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		o    *WriteOptions
+		ok   bool
+	}{
+		{"nil", nil, true},
+		{"zero", &WriteOptions{}, true},
+		{"command", &WriteOptions{Type: WriteTypeCommand}, true},
+		{"request at offset", &WriteOptions{Type: WriteTypeRequest, Offset: 20}, true},
+		{"reliable", &WriteOptions{Type: WriteTypeReliable, Offset: 20}, true},
+		{"unknown type", &WriteOptions{Type: "fast"}, false},
+		{"command at offset", &WriteOptions{Type: WriteTypeCommand, Offset: 20}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.o.Validate()
+			if tt.ok && err != nil {
+				t.Fatalf("Validate() failed, %v", err)
+			}
+
+			if !tt.ok && !errors.Is(err, ErrInvalidWriteOptions) {
+				t.Fatalf("got %v, want ErrInvalidWriteOptions", err)
+			}
+		})
+	}
+}
+
+func TestWriteOptionsVariants(t *testing.T) {
+	tests := []struct {
+		name string
+		o    *WriteOptions
+		want map[string]dbus.Variant
+	}{
+		{"nil", nil, map[string]dbus.Variant{}},
+		{"zero", &WriteOptions{}, map[string]dbus.Variant{}},
+		{
+			"all",
+			&WriteOptions{Offset: 20, Type: WriteTypeRequest, MTU: 185, PrepareAuthorize: true},
+			map[string]dbus.Variant{
+				"offset":            dbus.MakeVariant(uint16(20)),
+				"type":              dbus.MakeVariant("request"),
+				"mtu":               dbus.MakeVariant(uint16(185)),
+				"prepare-authorize": dbus.MakeVariant(true),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.o.Variants(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadOptionsVariants(t *testing.T) {
+	tests := []struct {
+		name string
+		o    *ReadOptions
+		want map[string]dbus.Variant
+	}{
+		{"nil", nil, map[string]dbus.Variant{}},
+		{"zero", &ReadOptions{}, map[string]dbus.Variant{}},
+		{
+			"offset",
+			&ReadOptions{Offset: 512},
+			map[string]dbus.Variant{"offset": dbus.MakeVariant(uint16(512))},
+		},
+		{
+			"all",
+			&ReadOptions{Offset: 512, MTU: 23},
+			map[string]dbus.Variant{
+				"offset": dbus.MakeVariant(uint16(512)),
+				"mtu":    dbus.MakeVariant(uint16(23)),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.o.Variants(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"sync"
 
 	dbus "github.com/godbus/dbus/v5"
 )
@@ -10,6 +11,7 @@ type (
 	GattService struct {
 		DBusObjectProxy
 
+		mut             sync.RWMutex
 		Characteristics map[string]*GattCharacteristic
 	}
 )
@@ -18,7 +20,7 @@ var ErrCharacterisicNotFound = errors.New("characteristic not found")
 
 func NewGattService(conn *dbus.Conn, path string) *GattService {
 	return &GattService{
-		DBusObjectProxy: newDBusObjectProxy(conn, destOrgBluez, "org.bluez.GattService1", path),
+		DBusObjectProxy: newDBusObjectProxy(conn, destOrgBluez, "org.bluez.GattService1", path, "UUID", "Primary", "Device"),
 		Characteristics: make(map[string]*GattCharacteristic),
 	}
 }
@@ -29,29 +31,28 @@ func (s *GattService) attachCharacteristic(c *GattCharacteristic) error {
 		return nil
 	}
 
+	s.mut.Lock()
 	s.Characteristics[uuid] = c
+	s.mut.Unlock()
 
 	return nil
 }
 
-func (s *GattService) UUID() (string, error) {
-	return s.GetStringProperty("UUID")
-}
-
-func (s *GattService) Primary() (bool, error) {
-	return s.GetBoolProperty("Primary")
-}
+func (s *GattService) detachCharacteristic(c *GattCharacteristic) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
 
-func (s *GattService) Device() (string, error) {
-	path, err := s.GetObjectPathProperty("Device")
-	if err != nil {
-		return "", err
+	for uuid, x := range s.Characteristics {
+		if x == c {
+			delete(s.Characteristics, uuid)
+		}
 	}
-
-	return string(path), nil
 }
 
 func (s *GattService) Characteristic(uuid string) (*GattCharacteristic, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
 	c, ok := s.Characteristics[uuid]
 	if !ok {
 		return nil, ErrServiceNotFound
@@ -61,6 +62,9 @@ func (s *GattService) Characteristic(uuid string) (*GattCharacteristic, error) {
 }
 
 func (s *GattService) Descriptor(uuid string) (*GattDescriptor, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
 	for _, c := range s.Characteristics {
 		if d, err := c.Descriptor(uuid); err == nil {
 			return d, nil
@@ -69,7 +73,3 @@ func (s *GattService) Descriptor(uuid string) (*GattDescriptor, error) {
 
 	return nil, ErrDescriptorNotFound
 }
-
-func (s *GattService) Includes() ([]string, error) {
-	return s.GetStringSliceProperty("Includes")
-}
@@ -9,4 +9,5 @@ require (
 	github.com/gorilla/websocket v1.4.2
 	github.com/influxdata/influxdb-client-go v1.0.0
 	github.com/influxdata/influxdb1-client v0.0.0-20191209144304-8bf82d3c094d
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+const (
+	// historyInterval is how often the device stores a sample in its
+	// history buffer
+	historyInterval = time.Minute
+
+	// historyMaxSamples is the size of the device history buffer
+	historyMaxSamples = 0xffff
+)
+
+// historyRequest returns the fff4 command asking the device to send its n
+// most recent history samples on fff3.
+func historyRequest(n int) []byte {
+	payload := []byte{0x09, 0x00, 0x00, 0x00, 0x00, 0x00}
+	binary.LittleEndian.PutUint16(payload[2:], uint16(n))
+
+	return payload
+}
+
+// historySamples returns the number of samples needed to cover the time
+// between since and now.
+func historySamples(since, now time.Time) int {
+	n := int(now.Sub(since)/historyInterval) + 1
+	if n > historyMaxSamples {
+		n = historyMaxSamples
+	}
+	if n < 1 {
+		n = 1
+	}
+
+	return n
+}
+
+// decodeHistory decodes a fff3 history frame. A frame starts with the little
+// endian age of the sample, counted in history intervals back from the time
+// of the request, followed by the probe temperatures encoded as in fff5
+// frames.
+func decodeHistory(data []byte, requested time.Time) (Measurement, error) {
+	if len(data) < 2 {
+		return Measurement{}, fmt.Errorf("history frame too short, %d bytes", len(data))
+	}
+
+	age := time.Duration(binary.LittleEndian.Uint16(data)) * historyInterval
+
+	data = data[2:]
+	temps := make([]Reading, len(data)/2)
+	for i := range temps {
+		temps[i] = decodeReading(data[2*i:])
+	}
+
+	return Measurement{Temperatures: temps, T: requested.Add(-age)}, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodeHistory(t *testing.T) {
+	requested := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		data []byte
+		want Measurement
+	}{
+		{
+			"latest sample",
+			[]byte{0x00, 0x00, 0xe5, 0x00, 0xf6, 0xff},
+			Measurement{
+				Temperatures: []Reading{{Value: 22.9}, {Unplugged: true}},
+				T:            requested,
+			},
+		},
+		{
+			"older sample",
+			[]byte{0x05, 0x00, 0x9c, 0xff},
+			Measurement{
+				Temperatures: []Reading{{Value: -10}},
+				T:            requested.Add(-5 * historyInterval),
+			},
+		},
+		{
+			"age only",
+			[]byte{0x01, 0x01},
+			Measurement{
+				Temperatures: []Reading{},
+				T:            requested.Add(-257 * historyInterval),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeHistory(tt.data, requested)
+			if err != nil {
+				t.Fatalf("decodeHistory() failed, %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeHistoryShort(t *testing.T) {
+	for _, data := range [][]byte{nil, {0x01}} {
+		if _, err := decodeHistory(data, time.Now()); err == nil {
+			t.Errorf("decodeHistory(%x) got nil, want an error", data)
+		}
+	}
+}
+
+func TestHistorySamples(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		since time.Time
+		want  int
+	}{
+		{"now", now, 1},
+		{"future", now.Add(time.Hour), 1},
+		{"ten minutes", now.Add(-10 * time.Minute), 11},
+		{"capped", now.Add(-100 * 24 * time.Hour), historyMaxSamples},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := historySamples(tt.since, now); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
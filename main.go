@@ -4,8 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
 	"time"
 
 	dbus "github.com/godbus/dbus/v5"
@@ -28,50 +33,155 @@ func printObjs(objs interface{}) {
 	fmt.Println(buf.String())
 }
 
-func scan(ctx context.Context, conn *dbus.Conn) {
-	adapter := NewAdapter(conn, "/org/bluez/hci0")
+// mustLoadConfig loads the configuration or exits, successfully if only the
+// usage was asked for.
+func mustLoadConfig(fs *flag.FlagSet, args []string) *Config {
+	cfg, err := LoadConfig(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal("LoadConfig() failed, ", err)
+	}
 
-	if err := adapter.SetPowered(true); err != nil {
-		log.Fatal("SetPowered() failed, ", err)
+	return cfg
+}
+
+// runScan implements the scan subcommand, it runs a discovery session and
+// prints the devices as they are found, updated and lost.
+func runScan(args []string) {
+	fs := flag.NewFlagSet("bbq scan", flag.ContinueOnError)
+	duration := fs.Duration("duration", 30*time.Second, "how long to scan, 0 to scan until interrupted")
+
+	// The usual configuration flags are accepted next to -duration
+	cfg := mustLoadConfig(fs, args)
+
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		log.Fatal("SystemBus() failed, ", err)
 	}
+	defer conn.Close()
+
+	manager := NewObjectManager(conn, "/")
+	if err := manager.Start(); err != nil {
+		log.Fatal("ObjectManager.Start() failed, ", err)
+	}
+	defer manager.Stop()
 
-	filter := map[string]interface{}{
-		"Transport":     "le",
-		"DuplicateData": true,
+	info, err := cfg.Adapter.Pick(manager.Adapters())
+	if err != nil {
+		log.Fatal("Pick() failed, ", err)
 	}
-	if err := adapter.SetDiscoveryFilter(filter); err != nil {
-		log.Fatal("SetDiscoveryFilter() failed, ", err)
+
+	log.Printf("Scanning on adapter %s (%s)", info.Path, info.Address)
+
+	adapter := NewAdapter(conn, info.Path)
+
+	if err := adapter.SetPowered(true); err != nil {
+		log.Fatal("SetPowered() failed, ", err)
 	}
 
-	d, err := adapter.StartDiscovery(ctx)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	duplicates := true
+	events, err := adapter.Discover(ctx, DiscoveryOptions{
+		Filter: &DiscoveryFilter{
+			Transport:     TransportLE,
+			DuplicateData: &duplicates,
+		},
+		Duration: *duration,
+	})
 	if err != nil {
-		log.Fatal("StartDiscovery() failed, ", err)
+		log.Fatal("Discover() failed, ", err)
+	}
+
+	for e := range events {
+		rssi := "-"
+		if e.RSSI != nil {
+			rssi = fmt.Sprint(*e.RSSI)
+		}
+
+		fmt.Printf("%-7s %s %4s %q %v\n", e.Type, e.Address, rssi, e.Name, e.UUIDs)
 	}
+}
+
+// discoverRSSI keeps a discovery session running on the adapter until ctx
+// is done, so that BlueZ reports the RSSI of the devices MinRSSI selectors
+// need. The session is restarted if it can't be started, e.g. while the
+// adapter is still being powered on.
+func discoverRSSI(ctx context.Context, adapter *Adapter) {
+	for {
+		events, err := adapter.Discover(ctx, DiscoveryOptions{
+			Filter: &DiscoveryFilter{Transport: TransportLE},
+		})
+		if err != nil {
+			log.Print("Discover() failed, ", err)
+		} else {
+			// The object manager follows the RSSI, the events
+			// themselves aren't needed
+			for range events {
+			}
+		}
 
-	log.Print(d)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(discoverRetry):
+		}
+	}
 }
 
+const (
+	// discoverRetry is how long to wait before restarting a discovery
+	// session that failed
+	discoverRetry = 10 * time.Second
+
+	// batteryInterval is how often the device is asked for its battery
+	// level
+	batteryInterval = time.Minute
+
+	// lowBatteryPercent is the level below which a warning is logged
+	lowBatteryPercent = 20
+)
+
 type InfluxDBWrapper struct {
-	c client.Client
+	c    client.Client
+	cfg  InfluxDBConfig
+	unit Unit
 }
 
-func NewInfluxDBWrapper(addr string) (*InfluxDBWrapper, error) {
+func NewInfluxDBWrapper(cfg InfluxDBConfig) (*InfluxDBWrapper, error) {
+	unit, err := ParseUnit(cfg.Unit)
+	if err != nil {
+		return nil, err
+	}
+
 	c, err := client.NewHTTPClient(client.HTTPConfig{
-		Addr: fmt.Sprintf("http://%s", addr),
+		Addr: fmt.Sprintf("http://%s", cfg.Addr),
 	})
 	if err != nil {
 		return nil, err
 	}
 
 	return &InfluxDBWrapper{
-		c: c,
+		c:    c,
+		cfg:  cfg,
+		unit: unit,
 	}, nil
 }
 
-func (w *InfluxDBWrapper) PushTemperatures(temps []int16, t time.Time) error {
+func (w *InfluxDBWrapper) PushMeasurement(m Measurement) error {
 	bps, err := client.NewBatchPoints(client.BatchPointsConfig{
 		Precision:       "ms",
-		Database:        "bbq",
+		Database:        w.cfg.Database,
 		RetentionPolicy: "",
 	})
 	if err != nil {
@@ -79,22 +189,31 @@ func (w *InfluxDBWrapper) PushTemperatures(temps []int16, t time.Time) error {
 	}
 
 	tags := map[string]string{
-		"cut": "brisket",
+		"cut":    w.cfg.Cut,
+		"unit":   w.unit.String(),
+		"device": m.Device,
+		"alias":  m.Alias,
+	}
+
+	// Unplugged probes are left out rather than written as a made up value
+	fields := make(map[string]interface{})
+	for i, temp := range m.Temperatures {
+		if temp.Unplugged {
+			continue
+		}
+
+		fields[fmt.Sprintf("probe%d", i+1)] = temp.Value.In(w.unit)
 	}
 
-	fields := map[string]interface{}{
-		"probe1": temps[0],
-		"probe2": temps[1],
-		"probe3": temps[2],
-		"probe4": temps[3],
-		"probe5": temps[4],
-		"probe6": temps[5],
+	// InfluxDB refuses points without fields
+	if len(fields) == 0 {
+		return nil
 	}
 
 	p, err := client.NewPoint("temperature",
 		tags,
 		fields,
-		t)
+		m.T)
 	if err != nil {
 		return err
 	}
@@ -104,59 +223,165 @@ func (w *InfluxDBWrapper) PushTemperatures(temps []int16, t time.Time) error {
 	return w.c.Write(bps)
 }
 
-func main() {
-	conn, err := dbus.SystemBus()
+// LastTimestamp returns the time of the most recent point written for the
+// device and the configured cut, or the zero time if there is none.
+func (w *InfluxDBWrapper) LastTimestamp(device string) (time.Time, error) {
+	q := client.NewQuery(
+		fmt.Sprintf(`SELECT * FROM "temperature" WHERE "cut" = '%s' AND "device" = '%s' ORDER BY time DESC LIMIT 1`,
+			influxQuote(w.cfg.Cut), influxQuote(device)),
+		w.cfg.Database, "")
+
+	resp, err := w.c.Query(q)
 	if err != nil {
-		log.Fatal("SystemBus() failed, ", err)
+		return time.Time{}, err
+	}
+	if err := resp.Error(); err != nil {
+		return time.Time{}, err
 	}
-	defer conn.Close()
 
-	sigch := make(chan *dbus.Signal, 128)
-	conn.Signal(sigch)
+	for _, r := range resp.Results {
+		for _, s := range r.Series {
+			if len(s.Values) == 0 || len(s.Values[0]) == 0 {
+				continue
+			}
 
-	manager := NewObjectManager(conn, "/")
+			ts, ok := s.Values[0][0].(string)
+			if !ok {
+				return time.Time{}, fmt.Errorf("unexpected time %v", s.Values[0][0])
+			}
 
-	devices, err := findDevices(manager, "BBQ")
-	if err != nil {
-		log.Fatal("findDevice() failed, ", err)
+			return time.Parse(time.RFC3339Nano, ts)
+		}
 	}
-	if len(devices) == 0 {
-		log.Fatal("Do devices detected", err)
+
+	return time.Time{}, nil
+}
+
+func influxQuote(s string) string {
+	return strings.ReplaceAll(s, "'", "\\'")
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "scan" {
+		runScan(os.Args[2:])
+		return
 	}
 
-	device := devices[0]
+	cfg := mustLoadConfig(flag.NewFlagSet("bbq", flag.ContinueOnError), os.Args[1:])
 
-	db, err := NewInfluxDBWrapper("localhost:8086")
+	conn, err := dbus.SystemBus()
 	if err != nil {
-		log.Fatal("NewInfluxDBWrapper() failed, ", err)
+		log.Fatal("SystemBus() failed, ", err)
 	}
+	defer conn.Close()
 
-	b, err := NewBbq(device)
-	if err != nil {
-		log.Fatal("NewBbq() failed, ", err)
+	manager := NewObjectManager(conn, "/")
+	if err := manager.Start(); err != nil {
+		log.Fatal("ObjectManager.Start() failed, ", err)
 	}
+	defer manager.Stop()
 
-	matchers := b.SignalMatchers()
-	for _, m := range matchers {
-		conn.AddMatchSignal(m.MatchOptions()...)
+	db, err := NewInfluxDBWrapper(cfg.InfluxDB)
+	if err != nil {
+		log.Fatal("NewInfluxDBWrapper() failed, ", err)
 	}
 
-	w := NewWeb()
+	sessions := NewSessions()
+
+	active := &ActiveAdapter{}
+
+	w := NewWeb(cfg.Web, sessions, active)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	adapters := manager.WatchAdapter(ctx, cfg.Adapter)
+
+	// supervisors holds the cancel function of each supervisor
+	supervisors := make(map[dbus.ObjectPath]context.CancelFunc)
+
+	// devices is nil, and so never fires, while there is no adapter
+	var devices <-chan *Device
+	var adapterPath string
+	stopDevices := func() {}
+
+	// stopAll gives up on the devices of the current adapter
+	stopAll := func() {
+		stopDevices()
+		for path, cancel := range supervisors {
+			cancel()
+			delete(supervisors, path)
+		}
+
+		devices = nil
+	}
 
 	for {
 		select {
-		case s := <-sigch:
-			for _, m := range matchers {
-				m.Match(s)
+		case a := <-adapters:
+			// Without an adapter there is nothing to supervise or
+			// power on
+			if a.Path == "" {
+				stopAll()
+				adapterPath = ""
+
+				log.Print("No adapter available")
+				active.Set(nil)
+				continue
 			}
 
-		case m := <-b.Measurements():
-			if err := db.PushTemperatures(m.Temperatures, m.T); err != nil {
-				log.Print("Failed to push temperatures, ", err)
+			if a.Path != adapterPath {
+				// The devices of the previous adapter are found
+				// again under the new one
+				stopAll()
+				adapterPath = a.Path
+
+				log.Printf("Using adapter %s (%s)", a.Path, a.Address)
+				active.Set(NewAdapter(conn, a.Path))
+
+				// Devices are supervised as they show up, there's
+				// no need for them to be known to BlueZ at startup
+				devCtx, devCancel := context.WithCancel(ctx)
+				stopDevices = devCancel
+				devices = manager.WatchDevices(devCtx, OnAdapter(a.Path, cfg.Device.Selectors().Match))
+
+				if cfg.Device.Selectors().NeedsDiscovery() {
+					go discoverRSSI(devCtx, NewAdapter(conn, a.Path))
+				}
+			}
+
+			if !a.Powered {
+				log.Printf("Adapter %s is powered off, powering on", a.Path)
+
+				if err := NewAdapter(conn, a.Path).SetPowered(true); err != nil {
+					log.Print("SetPowered() failed, ", err)
+				}
+			}
+
+		case device, ok := <-devices:
+			if !ok {
+				devices = nil
+				continue
+			}
+
+			// A device removed and added again is still supervised
+			// by its first supervisor
+			if _, ok := supervisors[device.Path()]; ok {
+				continue
+			}
+
+			log.Printf("Found device %s", device.Path())
+
+			sv, err := NewSupervisor(manager, device, cfg.Device, sessions, w.PushMeasurement, w.PushConnectionEvent)
+			if err != nil {
+				log.Printf("NewSupervisor(%s) failed, %v", device.Path(), err)
+				continue
 			}
 
-			w.PushMeasurement(m)
+			svCtx, svCancel := context.WithCancel(ctx)
+			supervisors[device.Path()] = svCancel
 
+			go sv.Run(svCtx, db, db)
 		}
 	}
 }
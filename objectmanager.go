@@ -1,16 +1,48 @@
 package main
 
 import (
-	"log"
-	"reflect"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
 
 	dbus "github.com/godbus/dbus/v5"
 )
 
 type (
+	// ObjectManager is a proxy for the BlueZ object manager. Once started
+	// it also keeps a live tree of the adapters, the devices and their
+	// GATT objects, following InterfacesAdded and InterfacesRemoved.
 	ObjectManager struct {
 		DBusObjectProxy
+
+		mut             sync.RWMutex
+		started         bool
+		attrs           map[string]map[string]interface{}
+		parents         map[string]string
+		adapters        map[string]*Adapter
+		devices         map[string]*Device
+		services        map[string]*GattService
+		characteristics map[string]*GattCharacteristic
+		descriptors     map[string]*GattDescriptor
+
+		// changed is closed, and replaced, whenever the tree changes
+		changed chan struct{}
+
+		routes []*SignalRoute
 	}
+
+	// DeviceMatch reports whether the device at path, with the given
+	// Device1 properties, is of interest.
+	DeviceMatch func(path string, attrs map[string]interface{}) bool
+)
+
+const (
+	ifaceAdapter1            = "org.bluez.Adapter1"
+	ifaceDevice1             = "org.bluez.Device1"
+	ifaceGattService1        = "org.bluez.GattService1"
+	ifaceGattCharacteristic1 = "org.bluez.GattCharacteristic1"
+	ifaceGattDescriptor1     = "org.bluez.GattDescriptor1"
 )
 
 func NewObjectManager(conn *dbus.Conn, path string) *ObjectManager {
@@ -18,7 +50,443 @@ func NewObjectManager(conn *dbus.Conn, path string) *ObjectManager {
 
 	return &ObjectManager{
 		DBusObjectProxy: newDBusObjectProxy(conn, destOrgBluez, "org.freedesktop.DBus.ObjectManager", path),
+		attrs:           make(map[string]map[string]interface{}),
+		parents:         make(map[string]string),
+		adapters:        make(map[string]*Adapter),
+		devices:         make(map[string]*Device),
+		services:        make(map[string]*GattService),
+		characteristics: make(map[string]*GattCharacteristic),
+		descriptors:     make(map[string]*GattDescriptor),
+		changed:         make(chan struct{}),
+	}
+}
+
+// Start subscribes to InterfacesAdded and InterfacesRemoved and loads the
+// current objects.
+func (m *ObjectManager) Start() error {
+	m.mut.Lock()
+	if m.started {
+		m.mut.Unlock()
+		return nil
+	}
+	m.started = true
+	m.mut.Unlock()
+
+	rules := []SignalRule{
+		{Sender: destOrgBluez, Interface: "org.freedesktop.DBus.ObjectManager", Member: "InterfacesAdded"},
+		{Sender: destOrgBluez, Interface: "org.freedesktop.DBus.ObjectManager", Member: "InterfacesRemoved"},
+		{Sender: destOrgBluez, Interface: "org.freedesktop.DBus.Properties", Member: "PropertiesChanged", Arg0: ifaceDevice1},
+		{Sender: destOrgBluez, Interface: "org.freedesktop.DBus.Properties", Member: "PropertiesChanged", Arg0: ifaceAdapter1},
+	}
+
+	// Subscribe before taking the snapshot so that nothing is missed in
+	// between, adding an object twice is harmless. A single route keeps
+	// the signals in bus order, so that once a device has its services
+	// resolved its GATT objects are in the tree.
+	rt, err := Router(m.conn).RegisterAll(rules, m.handleSignal)
+	if err != nil {
+		return err
+	}
+
+	m.routes = append(m.routes, rt)
+
+	paths, err := m.GetManagedObjects()
+	if err != nil {
+		return err
+	}
+
+	for path, ifaces := range paths {
+		m.addObject(path, ifaces)
+	}
+
+	return nil
+}
+
+// Stop unsubscribes from the object manager signals.
+func (m *ObjectManager) Stop() {
+	for _, rt := range m.routes {
+		if err := rt.Close(); err != nil {
+			debug("SignalRoute.Close() failed, %v", err)
+		}
+	}
+
+	m.routes = nil
+}
+
+func (m *ObjectManager) handleSignal(s *dbus.Signal) {
+	switch s.Name {
+	case "org.freedesktop.DBus.ObjectManager.InterfacesAdded":
+		m.handleInterfacesAdded(s)
+	case "org.freedesktop.DBus.ObjectManager.InterfacesRemoved":
+		m.handleInterfacesRemoved(s)
+	case "org.freedesktop.DBus.Properties.PropertiesChanged":
+		m.handleProperties(s)
+	}
+}
+
+func (m *ObjectManager) handleInterfacesAdded(s *dbus.Signal) {
+	if len(s.Body) != 2 {
+		return
+	}
+
+	path, ok := s.Body[0].(dbus.ObjectPath)
+	if !ok {
+		return
+	}
+
+	oifaces, ok := s.Body[1].(map[string]map[string]dbus.Variant)
+	if !ok {
+		return
+	}
+
+	ifaces := make(map[string]map[string]interface{})
+	for oiface, oattrs := range oifaces {
+		attrs := make(map[string]interface{})
+		for attr, value := range oattrs {
+			attrs[attr] = value.Value()
+		}
+
+		ifaces[oiface] = attrs
+	}
+
+	m.addObject(string(path), ifaces)
+}
+
+func (m *ObjectManager) handleInterfacesRemoved(s *dbus.Signal) {
+	if len(s.Body) != 2 {
+		return
+	}
+
+	path, ok := s.Body[0].(dbus.ObjectPath)
+	if !ok {
+		return
+	}
+
+	ifaces, ok := s.Body[1].([]string)
+	if !ok {
+		return
+	}
+
+	m.removeObject(string(path), ifaces)
+}
+
+// handleProperties keeps the cached Device1 and Adapter1 properties up to
+// date, as for instance the name of a device is often only known after it
+// was added, and adapters are powered on and off.
+func (m *ObjectManager) handleProperties(s *dbus.Signal) {
+	if len(s.Body) != 3 {
+		return
+	}
+
+	changed, ok := s.Body[1].(map[string]dbus.Variant)
+	if !ok {
+		return
+	}
+
+	invalidated, _ := s.Body[2].([]string)
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	attrs, ok := m.attrs[string(s.Path)]
+	if !ok {
+		return
+	}
+
+	for k, v := range changed {
+		attrs[k] = v.Value()
+	}
+
+	for _, k := range invalidated {
+		delete(attrs, k)
+	}
+
+	m.notify()
+}
+
+// addObject attaches the object to its parent. BlueZ adds parents before
+// their children, but children whose parent is unknown are attached when
+// the parent shows up.
+func (m *ObjectManager) addObject(path string, ifaces map[string]map[string]interface{}) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	for iface, attrs := range ifaces {
+		switch iface {
+		case ifaceAdapter1:
+			if _, ok := m.adapters[path]; ok {
+				continue
+			}
+
+			a := NewAdapter(m.conn, path)
+			a.Cache(attrs)
+			m.adapters[path] = a
+			m.attrs[path] = attrs
+
+		case ifaceDevice1:
+			if _, ok := m.devices[path]; ok {
+				continue
+			}
+
+			d := NewDevice(m.conn, path)
+			d.Cache(attrs)
+			m.devices[path] = d
+			m.attrs[path] = attrs
+
+			for p, s := range m.services {
+				if m.parents[p] == path {
+					d.attachService(s)
+				}
+			}
+
+		case ifaceGattService1:
+			if _, ok := m.services[path]; ok {
+				continue
+			}
+
+			s := NewGattService(m.conn, path)
+			s.Cache(attrs)
+			m.services[path] = s
+			m.parents[path] = objectPathAttr(attrs, "Device")
+
+			if d, ok := m.devices[m.parents[path]]; ok {
+				d.attachService(s)
+			}
+
+			for p, c := range m.characteristics {
+				if m.parents[p] == path {
+					s.attachCharacteristic(c)
+				}
+			}
+
+		case ifaceGattCharacteristic1:
+			if _, ok := m.characteristics[path]; ok {
+				continue
+			}
+
+			c := NewGattCharacteristic(m.conn, path)
+			c.Cache(attrs)
+			m.characteristics[path] = c
+			m.parents[path] = objectPathAttr(attrs, "Service")
+
+			if s, ok := m.services[m.parents[path]]; ok {
+				s.attachCharacteristic(c)
+			}
+
+			for p, d := range m.descriptors {
+				if m.parents[p] == path {
+					c.attachDescriptor(d)
+				}
+			}
+
+		case ifaceGattDescriptor1:
+			if _, ok := m.descriptors[path]; ok {
+				continue
+			}
+
+			d := NewGattDescriptor(m.conn, path)
+			d.Cache(attrs)
+			m.descriptors[path] = d
+			m.parents[path] = objectPathAttr(attrs, "Characteristic")
+
+			if c, ok := m.characteristics[m.parents[path]]; ok {
+				c.attachDescriptor(d)
+			}
+		}
+	}
+
+	m.notify()
+}
+
+func (m *ObjectManager) removeObject(path string, ifaces []string) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	for _, iface := range ifaces {
+		switch iface {
+		case ifaceAdapter1:
+			delete(m.adapters, path)
+			delete(m.attrs, path)
+
+		case ifaceDevice1:
+			delete(m.devices, path)
+			delete(m.attrs, path)
+
+		case ifaceGattService1:
+			s, ok := m.services[path]
+			if !ok {
+				continue
+			}
+
+			delete(m.services, path)
+			if d, ok := m.devices[m.parents[path]]; ok {
+				d.detachService(s)
+			}
+			delete(m.parents, path)
+
+		case ifaceGattCharacteristic1:
+			c, ok := m.characteristics[path]
+			if !ok {
+				continue
+			}
+
+			delete(m.characteristics, path)
+			if s, ok := m.services[m.parents[path]]; ok {
+				s.detachCharacteristic(c)
+			}
+			delete(m.parents, path)
+
+		case ifaceGattDescriptor1:
+			d, ok := m.descriptors[path]
+			if !ok {
+				continue
+			}
+
+			delete(m.descriptors, path)
+			if c, ok := m.characteristics[m.parents[path]]; ok {
+				c.detachDescriptor(d)
+			}
+			delete(m.parents, path)
+		}
+	}
+
+	m.notify()
+}
+
+// notify wakes up everyone waiting for the tree to change, m.mut must be
+// held.
+func (m *ObjectManager) notify() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// Changed returns a channel that is closed the next time the tree, or the
+// properties of a device or adapter, change.
+func (m *ObjectManager) Changed() <-chan struct{} {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	return m.changed
+}
+
+// Adapters returns the adapters currently in the tree.
+func (m *ObjectManager) Adapters() []AdapterInfo {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	adapters := make([]AdapterInfo, 0, len(m.adapters))
+	for path := range m.adapters {
+		adapters = append(adapters, newAdapterInfo(path, m.attrs[path]))
+	}
+
+	sort.Slice(adapters, func(i, j int) bool {
+		return adapters[i].Path < adapters[j].Path
+	})
+
+	return adapters
+}
+
+// Devices returns the devices currently in the tree accepted by match.
+func (m *ObjectManager) Devices(match DeviceMatch) []*Device {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	devices := make([]*Device, 0)
+	for path, d := range m.devices {
+		if match(path, m.attrs[path]) {
+			devices = append(devices, d)
+		}
+	}
+
+	return devices
+}
+
+// WatchDevices sends every device accepted by match on the returned channel,
+// those already in the tree as well as those added later, until ctx is
+// done. A device removed and added again is sent again.
+func (m *ObjectManager) WatchDevices(ctx context.Context, match DeviceMatch) <-chan *Device {
+	ch := make(chan *Device)
+
+	go func() {
+		defer close(ch)
+
+		seen := make(map[*Device]bool)
+
+		for {
+			changed := m.Changed()
+
+			for _, d := range m.Devices(match) {
+				if seen[d] {
+					continue
+				}
+
+				select {
+				case ch <- d:
+					seen[d] = true
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// ServicesResolved reports whether the device at path has its services
+// resolved, as last seen in the tree. Once it does the GATT objects of the
+// device are in the tree too.
+func (m *ObjectManager) ServicesResolved(path string) bool {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	resolved, _ := m.attrs[path]["ServicesResolved"].(bool)
+
+	return resolved
+}
+
+// DeviceServices returns the GATT services of the device at path in the
+// tree, by UUID.
+func (m *ObjectManager) DeviceServices(path string) (map[string]*GattService, error) {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	if _, ok := m.devices[path]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrDeviceNotFound, path)
+	}
+
+	services := make(map[string]*GattService)
+	for p, s := range m.services {
+		if m.parents[p] != path {
+			continue
+		}
+
+		uuid, err := s.UUID()
+		if err != nil {
+			return nil, err
+		}
+
+		services[uuid] = s
 	}
+
+	return services, nil
+}
+
+func objectPathAttr(attrs map[string]interface{}, key string) string {
+	switch v := attrs[key].(type) {
+	case dbus.ObjectPath:
+		return string(v)
+	case string:
+		return v
+	}
+
+	return ""
 }
 
 func (m *ObjectManager) GetManagedObjects() (map[string]map[string]map[string]interface{}, error) {
@@ -50,6 +518,5 @@ func (m *ObjectManager) GetManagedObjects() (map[string]map[string]map[string]in
 		paths[string(opath)] = ifaces
 	}
 
-	log.Println("paths -> ", reflect.TypeOf(paths))
 	return paths, nil
 }
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type (
+	// DeviceSelector selects devices by their Device1 properties. Every
+	// criterion that is set must match, unset criteria match anything.
+	DeviceSelector struct {
+		Name    string `yaml:"name"`
+		Alias   string `yaml:"alias"`
+		Address string `yaml:"address"`
+
+		// UUIDs lists service UUIDs that must all be advertised, the
+		// 16 bit short form, e.g. fff0, may be used
+		UUIDs []string `yaml:"uuids"`
+
+		// ManufacturerData lists patterns that must all match
+		ManufacturerData []ManufacturerPattern `yaml:"manufacturer_data"`
+
+		// MinRSSI, if not nil, is the weakest signal accepted, devices
+		// with no known RSSI are rejected. BlueZ only knows the RSSI
+		// while discovering, so the daemon keeps a discovery session
+		// running while such a selector is configured.
+		MinRSSI *int16 `yaml:"min_rssi"`
+	}
+
+	// ManufacturerPattern matches manufacturer data of company ID whose
+	// payload starts with the hex encoded Prefix.
+	ManufacturerPattern struct {
+		ID     uint16 `yaml:"id"`
+		Prefix string `yaml:"prefix"`
+	}
+
+	// DeviceSelectors selects the devices matching any of its selectors.
+	DeviceSelectors []DeviceSelector
+)
+
+const bluetoothBaseUUID = "-0000-1000-8000-00805f9b34fb"
+
+var ErrInvalidSelector = errors.New("invalid device selector")
+
+// ParseDeviceSelector parses the command line form of a selector, a comma
+// separated list of key=value criteria:
+//
+//	name=BBQ,address=AA:BB:CC:DD:EE:FF,alias=Smoker,uuid=fff0,mfr=0x0590:ab01,rssi=-80
+//
+// uuid and mfr may be repeated.
+func ParseDeviceSelector(s string) (DeviceSelector, error) {
+	var sel DeviceSelector
+
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return sel, fmt.Errorf("%w: %q is not key=value", ErrInvalidSelector, part)
+		}
+
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+
+		switch key {
+		case "name":
+			sel.Name = value
+		case "alias":
+			sel.Alias = value
+		case "address":
+			sel.Address = value
+		case "uuid":
+			sel.UUIDs = append(sel.UUIDs, value)
+		case "mfr":
+			idPrefix := strings.SplitN(value, ":", 2)
+
+			id, err := strconv.ParseUint(idPrefix[0], 0, 16)
+			if err != nil {
+				return sel, fmt.Errorf("%w: manufacturer id %q", ErrInvalidSelector, idPrefix[0])
+			}
+
+			p := ManufacturerPattern{ID: uint16(id)}
+			if len(idPrefix) == 2 {
+				p.Prefix = idPrefix[1]
+			}
+
+			sel.ManufacturerData = append(sel.ManufacturerData, p)
+		case "rssi":
+			rssi, err := strconv.ParseInt(value, 10, 16)
+			if err != nil {
+				return sel, fmt.Errorf("%w: rssi %q", ErrInvalidSelector, value)
+			}
+
+			min := int16(rssi)
+			sel.MinRSSI = &min
+		default:
+			return sel, fmt.Errorf("%w: unknown key %q", ErrInvalidSelector, key)
+		}
+	}
+
+	return sel, sel.Validate()
+}
+
+func (s DeviceSelector) Validate() error {
+	for _, p := range s.ManufacturerData {
+		if _, err := hex.DecodeString(p.Prefix); err != nil {
+			return fmt.Errorf("%w: manufacturer data prefix %q", ErrInvalidSelector, p.Prefix)
+		}
+	}
+
+	if s.Name == "" && s.Alias == "" && s.Address == "" && len(s.UUIDs) == 0 &&
+		len(s.ManufacturerData) == 0 && s.MinRSSI == nil {
+		return fmt.Errorf("%w: no criteria", ErrInvalidSelector)
+	}
+
+	return nil
+}
+
+// Match is a DeviceMatch accepting the devices selected.
+func (s DeviceSelector) Match(path string, attrs map[string]interface{}) bool {
+	if s.Name != "" && attrs["Name"] != s.Name {
+		return false
+	}
+
+	if s.Alias != "" && attrs["Alias"] != s.Alias {
+		return false
+	}
+
+	if s.Address != "" {
+		addr, _ := attrs["Address"].(string)
+		if !strings.EqualFold(addr, s.Address) {
+			return false
+		}
+	}
+
+	if len(s.UUIDs) != 0 {
+		uuids, _ := attrs["UUIDs"].([]string)
+		for _, want := range s.UUIDs {
+			if !containsUUID(uuids, want) {
+				return false
+			}
+		}
+	}
+
+	if len(s.ManufacturerData) != 0 {
+		data := manufacturerData(attrs["ManufacturerData"])
+		for _, p := range s.ManufacturerData {
+			payload, ok := data[p.ID]
+			if !ok {
+				return false
+			}
+
+			prefix, _ := hex.DecodeString(p.Prefix)
+			if !bytes.HasPrefix(payload, prefix) {
+				return false
+			}
+		}
+	}
+
+	if s.MinRSSI != nil {
+		rssi, ok := attrs["RSSI"].(int16)
+		if !ok || rssi < *s.MinRSSI {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NeedsDiscovery reports whether any selector relies on the RSSI, which is
+// only known while discovering.
+func (ss DeviceSelectors) NeedsDiscovery() bool {
+	for _, s := range ss {
+		if s.MinRSSI != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Match is a DeviceMatch accepting the devices selected by any selector.
+func (ss DeviceSelectors) Match(path string, attrs map[string]interface{}) bool {
+	for _, s := range ss {
+		if s.Match(path, attrs) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fullUUID expands 16 and 32 bit short UUIDs to 128 bits.
+func fullUUID(uuid string) string {
+	uuid = strings.ToLower(uuid)
+
+	switch len(uuid) {
+	case 4:
+		return "0000" + uuid + bluetoothBaseUUID
+	case 8:
+		return uuid + bluetoothBaseUUID
+	}
+
+	return uuid
+}
+
+func containsUUID(uuids []string, uuid string) bool {
+	uuid = fullUUID(uuid)
+
+	for _, u := range uuids {
+		if strings.ToLower(u) == uuid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// manufacturerData unwraps the ManufacturerData property value, it's nil if
+// the value can't be decoded.
+func manufacturerData(v interface{}) map[uint16][]byte {
+	var data map[uint16][]byte
+	if err := decodeValue("ManufacturerData", v, reflect.ValueOf(&data).Elem()); err != nil {
+		debug("manufacturerData() failed, %v", err)
+	}
+
+	return data
+}
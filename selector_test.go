@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	dbus "github.com/godbus/dbus/v5"
+)
+
+func TestParseDeviceSelector(t *testing.T) {
+	rssi := int16(-80)
+
+	tests := []struct {
+		name string
+		s    string
+		want DeviceSelector
+	}{
+		{"name", "name=BBQ", DeviceSelector{Name: "BBQ"}},
+		{"spaces", " name = BBQ ", DeviceSelector{Name: "BBQ"}},
+		{
+			"everything",
+			"name=BBQ,alias=Smoker,address=AA:BB:CC:DD:EE:FF,uuid=fff0,uuid=fff1,mfr=0x0590:ab01,mfr=12,rssi=-80",
+			DeviceSelector{
+				Name:    "BBQ",
+				Alias:   "Smoker",
+				Address: "AA:BB:CC:DD:EE:FF",
+				UUIDs:   []string{"fff0", "fff1"},
+				ManufacturerData: []ManufacturerPattern{
+					{ID: 0x0590, Prefix: "ab01"},
+					{ID: 12},
+				},
+				MinRSSI: &rssi,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDeviceSelector(tt.s)
+			if err != nil {
+				t.Fatalf("ParseDeviceSelector() failed, %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDeviceSelectorErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"name",
+		"colour=red",
+		"mfr=acme",
+		"mfr=0x10000",
+		"mfr=0x0590:xyz",
+		"rssi=loud",
+		"rssi=-40000",
+	} {
+		if _, err := ParseDeviceSelector(s); !errors.Is(err, ErrInvalidSelector) {
+			t.Errorf("ParseDeviceSelector(%q) got %v, want ErrInvalidSelector", s, err)
+		}
+	}
+}
+
+func TestDeviceSelectorMatch(t *testing.T) {
+	attrs := map[string]interface{}{
+		"Name":    "BBQ",
+		"Alias":   "Smoker",
+		"Address": "AA:BB:CC:DD:EE:FF",
+		"UUIDs":   []string{"0000fff0-0000-1000-8000-00805F9B34FB"},
+		"RSSI":    int16(-70),
+		"ManufacturerData": map[uint16]dbus.Variant{
+			0x0590: dbus.MakeVariant([]byte{0xab, 0x01, 0x02}),
+		},
+	}
+
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"name=BBQ", true},
+		{"name=iBBQ", false},
+		{"alias=Smoker", true},
+		{"address=aa:bb:cc:dd:ee:ff", true},
+		{"address=AA:BB:CC:DD:EE:00", false},
+		{"uuid=fff0", true},
+		{"uuid=fff0,uuid=fff1", false},
+		{"mfr=0x0590:ab01", true},
+		{"mfr=0x0590:ab02", false},
+		{"mfr=0x0001", false},
+		{"rssi=-80", true},
+		{"rssi=-60", false},
+		{"name=BBQ,rssi=-60", false},
+	}
+
+	for _, tt := range tests {
+		sel, err := ParseDeviceSelector(tt.s)
+		if err != nil {
+			t.Fatalf("ParseDeviceSelector(%q) failed, %v", tt.s, err)
+		}
+
+		if got := sel.Match("/org/bluez/hci0/dev_AA_BB_CC_DD_EE_FF", attrs); got != tt.want {
+			t.Errorf("%q got %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+type (
+	// Session is a connected thermometer.
+	Session struct {
+		ID    string
+		Alias string
+
+		dev *Device
+		t   Thermometer
+	}
+
+	// Sessions is the set of thermometers currently being read, keyed by
+	// session ID.
+	Sessions struct {
+		mut      sync.RWMutex
+		sessions map[string]*Session
+	}
+
+	// MeasurementSink receives the measurements of all sessions.
+	MeasurementSink interface {
+		PushMeasurement(m Measurement) error
+	}
+
+	// HistorySource is asked for the time of the last measurement written
+	// for a device so that the gap can be filled.
+	HistorySource interface {
+		LastTimestamp(device string) (time.Time, error)
+	}
+)
+
+// NewSession picks a driver for the device and connects to it. The session
+// is identified by the device address.
+func NewSession(dev *Device, cfg DeviceConfig) (*Session, error) {
+	id, err := dev.Address()
+	if err != nil {
+		return nil, err
+	}
+
+	alias, err := dev.Alias()
+	if err != nil {
+		alias = id
+	}
+
+	driver, err := DriverFor(dev)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[%s] Using driver %s for %s", id, driver.Name(), dev.Path())
+
+	t, err := driver.Connect(dev, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Session{
+		ID:    id,
+		Alias: alias,
+		dev:   dev,
+		t:     t,
+	}
+
+	if us, ok := t.(UnitSetter); ok {
+		unit, err := ParseUnit(cfg.Unit)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		if err := us.SetUnit(unit); err != nil {
+			log.Printf("[%s] SetUnit() failed, %v", id, err)
+		}
+	}
+
+	return s, nil
+}
+
+// Thermometer returns the connected thermometer.
+func (s *Session) Thermometer() Thermometer {
+	return s.t
+}
+
+// Run forwards the measurements of the session, tagged with the session
+// ID, to sink until ctx is done or the thermometer is closed. Measurements
+// are also handed to observe, if not nil.
+func (s *Session) Run(ctx context.Context, sink MeasurementSink, history HistorySource, observe func(Measurement)) {
+	var settings chan SettingsEvent
+	sr, ok := s.t.(SettingsReporter)
+	if ok {
+		settings = sr.Settings()
+
+		if err := sr.RequestVersion(); err != nil {
+			log.Printf("[%s] RequestVersion() failed, %v", s.ID, err)
+		}
+		if err := sr.RequestBattery(); err != nil {
+			log.Printf("[%s] RequestBattery() failed, %v", s.ID, err)
+		}
+	}
+
+	var hist chan Measurement
+	if hr, ok := s.t.(HistoryReader); ok {
+		hist = hr.History()
+
+		// Fill the gap since the last point written, if any, from the
+		// device history buffer
+		last, err := history.LastTimestamp(s.ID)
+		if err != nil {
+			log.Printf("[%s] LastTimestamp() failed, %v", s.ID, err)
+		} else if !last.IsZero() {
+			if err := hr.RequestHistory(last); err != nil {
+				log.Printf("[%s] RequestHistory() failed, %v", s.ID, err)
+			}
+		}
+	}
+
+	battery := time.NewTicker(batteryInterval)
+	defer battery.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case m, ok := <-s.t.Measurements():
+			if !ok {
+				return
+			}
+
+			m = s.tag(m)
+			if err := sink.PushMeasurement(m); err != nil {
+				log.Printf("[%s] Failed to push temperatures, %v", s.ID, err)
+			}
+
+			if observe != nil {
+				observe(m)
+			}
+
+		case m, ok := <-hist:
+			if !ok {
+				hist = nil
+				continue
+			}
+
+			if err := sink.PushMeasurement(s.tag(m)); err != nil {
+				log.Printf("[%s] Failed to push history, %v", s.ID, err)
+			}
+
+		case e, ok := <-settings:
+			if !ok {
+				settings = nil
+				continue
+			}
+
+			s.logSettings(e)
+
+		case <-battery.C:
+			if sr == nil {
+				continue
+			}
+
+			if err := sr.RequestBattery(); err != nil {
+				log.Printf("[%s] RequestBattery() failed, %v", s.ID, err)
+			}
+		}
+	}
+}
+
+func (s *Session) tag(m Measurement) Measurement {
+	m.Device = s.ID
+	m.Alias = s.Alias
+
+	return m
+}
+
+func (s *Session) logSettings(e SettingsEvent) {
+	switch e := e.(type) {
+	case BatteryEvent:
+		log.Printf("[%s] Battery at %d%% (%d/%d mV)", s.ID, e.Percent, e.Voltage, e.MaxVoltage)
+		if e.Percent < lowBatteryPercent {
+			log.Printf("[%s] WARNING: battery low, %d%%", s.ID, e.Percent)
+		}
+	case UnitEvent:
+		log.Printf("[%s] Device unit set to %v", s.ID, e.Unit)
+	case VersionEvent:
+		log.Printf("[%s] Device firmware version %s", s.ID, e.Version)
+	}
+}
+
+// Close closes the thermometer.
+func (s *Session) Close() error {
+	return s.t.Close()
+}
+
+func NewSessions() *Sessions {
+	return &Sessions{
+		sessions: make(map[string]*Session),
+	}
+}
+
+func (ss *Sessions) Add(s *Session) {
+	ss.mut.Lock()
+	defer ss.mut.Unlock()
+
+	ss.sessions[s.ID] = s
+}
+
+func (ss *Sessions) Remove(id string) {
+	ss.mut.Lock()
+	defer ss.mut.Unlock()
+
+	delete(ss.sessions, id)
+}
+
+func (ss *Sessions) Get(id string) (*Session, bool) {
+	ss.mut.RLock()
+	defer ss.mut.RUnlock()
+
+	s, ok := ss.sessions[id]
+
+	return s, ok
+}
+
+// Controller returns the target controller of the session with the given
+// ID, if the session exists and its thermometer can be programmed.
+func (ss *Sessions) Controller(id string) (TargetController, bool) {
+	s, ok := ss.Get(id)
+	if !ok {
+		return nil, false
+	}
+
+	ctl, ok := s.t.(TargetController)
+
+	return ctl, ok
+}
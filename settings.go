@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Settings results are reported on fff1. The first byte of a frame is the
+// command it is a response to, the rest is command specific.
+const (
+	settingsUnit    = 0x02
+	settingsBattery = 0x24
+	settingsVersion = 0x26
+)
+
+type (
+	// SettingsEvent is a decoded fff1 settings result, one of
+	// BatteryEvent, UnitEvent or VersionEvent.
+	SettingsEvent interface {
+		settingsEvent()
+	}
+
+	BatteryEvent struct {
+		Voltage    uint16
+		MaxVoltage uint16
+		Percent    int
+		T          time.Time
+	}
+
+	UnitEvent struct {
+		Unit Unit
+		T    time.Time
+	}
+
+	VersionEvent struct {
+		Version string
+		T       time.Time
+	}
+)
+
+var ErrUnknownSettingsResult = errors.New("unknown settings result")
+
+func (BatteryEvent) settingsEvent() {}
+func (UnitEvent) settingsEvent()    {}
+func (VersionEvent) settingsEvent() {}
+
+func decodeSettingsResult(data []byte, t time.Time) (SettingsEvent, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: empty frame", ErrUnknownSettingsResult)
+	}
+
+	switch data[0] {
+	case settingsBattery:
+		// 0x24, current voltage and max voltage in millivolts, both
+		// little endian
+		if len(data) < 5 {
+			return nil, fmt.Errorf("battery result too short, %d bytes", len(data))
+		}
+
+		e := BatteryEvent{
+			Voltage:    binary.LittleEndian.Uint16(data[1:]),
+			MaxVoltage: binary.LittleEndian.Uint16(data[3:]),
+			T:          t,
+		}
+
+		if e.MaxVoltage != 0 {
+			e.Percent = int(100 * uint32(e.Voltage) / uint32(e.MaxVoltage))
+		}
+		if e.Percent > 100 {
+			e.Percent = 100
+		}
+
+		return e, nil
+
+	case settingsUnit:
+		// 0x02, 0x00 for Celsius and 0x01 for Fahrenheit
+		if len(data) < 2 {
+			return nil, fmt.Errorf("unit result too short, %d bytes", len(data))
+		}
+
+		u := Celsius
+		if data[1] == 0x01 {
+			u = Fahrenheit
+		}
+
+		return UnitEvent{Unit: u, T: t}, nil
+
+	case settingsVersion:
+		// 0x26, major, minor, patch
+		if len(data) < 4 {
+			return nil, fmt.Errorf("version result too short, %d bytes", len(data))
+		}
+
+		return VersionEvent{
+			Version: fmt.Sprintf("%d.%d.%d", data[1], data[2], data[3]),
+			T:       t,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: 0x%02x", ErrUnknownSettingsResult, data[0])
+}
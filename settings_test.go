@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodeSettingsResult(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		data []byte
+		want SettingsEvent
+	}{
+		{
+			"battery",
+			[]byte{0x24, 0x60, 0x10, 0xa0, 0x13, 0x00},
+			BatteryEvent{Voltage: 4192, MaxVoltage: 5024, Percent: 83, T: now},
+		},
+		{
+			"battery above max",
+			[]byte{0x24, 0xb0, 0x13, 0xa0, 0x13},
+			BatteryEvent{Voltage: 5040, MaxVoltage: 5024, Percent: 100, T: now},
+		},
+		{
+			"battery without max",
+			[]byte{0x24, 0x60, 0x10, 0x00, 0x00},
+			BatteryEvent{Voltage: 4192, T: now},
+		},
+		{"celsius", []byte{0x02, 0x00}, UnitEvent{Unit: Celsius, T: now}},
+		{"fahrenheit", []byte{0x02, 0x01}, UnitEvent{Unit: Fahrenheit, T: now}},
+		{"version", []byte{0x26, 0x01, 0x02, 0x03}, VersionEvent{Version: "1.2.3", T: now}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeSettingsResult(tt.data, now)
+			if err != nil {
+				t.Fatalf("decodeSettingsResult() failed, %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeSettingsResultErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		unknown bool
+	}{
+		{"empty", nil, true},
+		{"unknown command", []byte{0x42, 0x00}, true},
+		{"short battery", []byte{0x24, 0x60, 0x10}, false},
+		{"short unit", []byte{0x02}, false},
+		{"short version", []byte{0x26, 0x01}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeSettingsResult(tt.data, time.Now())
+			if err == nil {
+				t.Fatal("got nil, want an error")
+			}
+
+			if errors.Is(err, ErrUnknownSettingsResult) != tt.unknown {
+				t.Errorf("got %v, want ErrUnknownSettingsResult %v", err, tt.unknown)
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	dbus "github.com/godbus/dbus/v5"
+)
+
+type (
+	SignalHandler func(s *dbus.Signal)
+
+	// SignalRule selects the signals handed to a handler. Empty fields
+	// match anything. Path and PathNamespace are mutually exclusive,
+	// PathNamespace matches the path itself and every path below it.
+	SignalRule struct {
+		// Sender is only used for the bus side match rule, signals
+		// carry the unique name of their sender
+		Sender string
+
+		Path          dbus.ObjectPath
+		PathNamespace dbus.ObjectPath
+		Interface     string
+		Member        string
+		Arg0          string
+	}
+
+	// SignalRouter receives every signal of a connection and dispatches
+	// it to the handlers whose rule matches. Each handler runs in its own
+	// goroutine with its own queue, signals that don't fit in the queue
+	// are dropped and counted. A handler sees its signals in bus order,
+	// signals handed to different handlers aren't ordered.
+	SignalRouter struct {
+		conn *dbus.Conn
+		ch   chan *dbus.Signal
+
+		mut    sync.RWMutex
+		routes map[routeKey]map[*SignalRoute]bool
+	}
+
+	// SignalRoute is a handler registered with a SignalRouter for one or
+	// more rules.
+	SignalRoute struct {
+		router  *SignalRouter
+		rules   []SignalRule
+		ch      chan *dbus.Signal
+		done    chan struct{}
+		dropped uint64
+		once    sync.Once
+	}
+
+	// routeKey indexes routes by what can be looked up from a signal, the
+	// path, or namespace, the interface and the member
+	routeKey struct {
+		path      dbus.ObjectPath
+		namespace bool
+		iface     string
+		member    string
+	}
+)
+
+// routeQueue is how many signals a handler may lag behind before signals
+// are dropped
+const routeQueue = 64
+
+var (
+	routersMut sync.Mutex
+	routers    = make(map[*dbus.Conn]*SignalRouter)
+)
+
+// Router returns the signal router of the connection, creating it the first
+// time.
+func Router(conn *dbus.Conn) *SignalRouter {
+	routersMut.Lock()
+	defer routersMut.Unlock()
+
+	r, ok := routers[conn]
+	if !ok {
+		r = newSignalRouter(conn)
+		routers[conn] = r
+	}
+
+	return r
+}
+
+func newSignalRouter(conn *dbus.Conn) *SignalRouter {
+	r := &SignalRouter{
+		conn:   conn,
+		ch:     make(chan *dbus.Signal, 128),
+		routes: make(map[routeKey]map[*SignalRoute]bool),
+	}
+
+	conn.Signal(r.ch)
+
+	go func() {
+		for s := range r.ch {
+			r.dispatch(s)
+		}
+	}()
+
+	return r
+}
+
+// Register adds the bus side match rule and starts handing the matching
+// signals to handler.
+func (r *SignalRouter) Register(rule SignalRule, handler SignalHandler) (*SignalRoute, error) {
+	return r.RegisterAll([]SignalRule{rule}, handler)
+}
+
+// RegisterAll adds the bus side match rules and starts handing the signals
+// matching any of them to handler, through a single queue so that handler
+// sees them in bus order. A signal matching several rules is handed over
+// once.
+func (r *SignalRouter) RegisterAll(rules []SignalRule, handler SignalHandler) (*SignalRoute, error) {
+	for i, rule := range rules {
+		if err := r.conn.AddMatchSignal(rule.matchOptions()...); err != nil {
+			for _, added := range rules[:i] {
+				r.conn.RemoveMatchSignal(added.matchOptions()...)
+			}
+
+			return nil, err
+		}
+	}
+
+	rt := &SignalRoute{
+		router: r,
+		rules:  rules,
+		ch:     make(chan *dbus.Signal, routeQueue),
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(rt.done)
+
+		for s := range rt.ch {
+			handler(s)
+		}
+	}()
+
+	r.mut.Lock()
+	r.add(rt)
+	r.mut.Unlock()
+
+	return rt, nil
+}
+
+// add indexes the route under the key of each of its rules, r.mut must be
+// held.
+func (r *SignalRouter) add(rt *SignalRoute) {
+	for _, rule := range rt.rules {
+		key := rule.key()
+		if r.routes[key] == nil {
+			r.routes[key] = make(map[*SignalRoute]bool)
+		}
+		r.routes[key][rt] = true
+	}
+}
+
+// dispatch hands the signal to the matching routes. Routes are looked up by
+// the signal path and every namespace above it, each with and without the
+// interface and member, so the cost doesn't grow with the number of routes.
+func (r *SignalRouter) dispatch(s *dbus.Signal) {
+	iface, member := s.Name, ""
+	if i := strings.LastIndex(s.Name, "."); i != -1 {
+		iface, member = s.Name[:i], s.Name[i+1:]
+	}
+
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+
+	var matched []*SignalRoute
+
+	matched = r.match(matched, s, routeKey{}, iface, member)
+	matched = r.match(matched, s, routeKey{path: s.Path}, iface, member)
+
+	for ns := s.Path; ; ns = parentPath(ns) {
+		matched = r.match(matched, s, routeKey{path: ns, namespace: true}, iface, member)
+
+		if ns == "/" || ns == "" {
+			break
+		}
+	}
+
+	for _, rt := range matched {
+		select {
+		case rt.ch <- s:
+		default:
+			if atomic.AddUint64(&rt.dropped, 1) == 1 {
+				debug("SignalRouter dropped signal %v for %+v", s.Name, rt.rules)
+			}
+		}
+	}
+}
+
+// match appends the routes under key, with and without the interface and
+// member, that accept the signal and aren't in matched yet.
+func (r *SignalRouter) match(matched []*SignalRoute, s *dbus.Signal, key routeKey, iface, member string) []*SignalRoute {
+	for _, i := range []string{iface, ""} {
+		for _, m := range []string{member, ""} {
+			key.iface, key.member = i, m
+
+		routes:
+			for rt := range r.routes[key] {
+				if !rt.accepts(s, key) {
+					continue
+				}
+
+				for _, other := range matched {
+					if other == rt {
+						continue routes
+					}
+				}
+
+				matched = append(matched, rt)
+			}
+		}
+	}
+
+	return matched
+}
+
+// accepts reports whether one of the rules indexed under key accepts the
+// signal, the key already matched everything but Arg0.
+func (rt *SignalRoute) accepts(s *dbus.Signal, key routeKey) bool {
+	for _, rule := range rt.rules {
+		if rule.key() != key {
+			continue
+		}
+
+		if rule.Arg0 == "" || (len(s.Body) != 0 && s.Body[0] == rule.Arg0) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Close unregisters the route and removes its bus side match rules. Signals
+// already queued are still handled.
+func (rt *SignalRoute) Close() error {
+	var err error
+
+	rt.once.Do(func() {
+		r := rt.router
+
+		r.mut.Lock()
+		for _, rule := range rt.rules {
+			key := rule.key()
+			delete(r.routes[key], rt)
+			if len(r.routes[key]) == 0 {
+				delete(r.routes, key)
+			}
+		}
+		close(rt.ch)
+		r.mut.Unlock()
+
+		for _, rule := range rt.rules {
+			if e := r.conn.RemoveMatchSignal(rule.matchOptions()...); e != nil && err == nil {
+				err = e
+			}
+		}
+	})
+
+	return err
+}
+
+// Done is closed once the route is closed and its handler has returned for
+// the last time.
+func (rt *SignalRoute) Done() <-chan struct{} {
+	return rt.done
+}
+
+// Dropped returns how many signals were dropped because the handler didn't
+// keep up.
+func (rt *SignalRoute) Dropped() uint64 {
+	return atomic.LoadUint64(&rt.dropped)
+}
+
+func (rule SignalRule) key() routeKey {
+	if rule.PathNamespace != "" {
+		return routeKey{path: rule.PathNamespace, namespace: true, iface: rule.Interface, member: rule.Member}
+	}
+
+	return routeKey{path: rule.Path, iface: rule.Interface, member: rule.Member}
+}
+
+func (rule SignalRule) matchOptions() []dbus.MatchOption {
+	var options []dbus.MatchOption
+
+	if rule.Sender != "" {
+		options = append(options, dbus.WithMatchSender(rule.Sender))
+	}
+	if rule.Path != "" {
+		options = append(options, dbus.WithMatchObjectPath(rule.Path))
+	}
+	if rule.PathNamespace != "" {
+		options = append(options, dbus.WithMatchPathNamespace(rule.PathNamespace))
+	}
+	if rule.Interface != "" {
+		options = append(options, dbus.WithMatchInterface(rule.Interface))
+	}
+	if rule.Member != "" {
+		options = append(options, dbus.WithMatchMember(rule.Member))
+	}
+	if rule.Arg0 != "" {
+		options = append(options, dbus.WithMatchOption("arg0", rule.Arg0))
+	}
+
+	return options
+}
+
+// parentPath returns the parent of an object path, "/" for top level paths.
+func parentPath(p dbus.ObjectPath) dbus.ObjectPath {
+	i := strings.LastIndex(string(p), "/")
+	if i <= 0 {
+		return "/"
+	}
+
+	return p[:i]
+}
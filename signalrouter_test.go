@@ -0,0 +1,204 @@
+package main
+
+import (
+	"testing"
+
+	dbus "github.com/godbus/dbus/v5"
+)
+
+// addRoute registers a route without a connection, its queue is read
+// directly by the tests rather than by a handler.
+func addRoute(r *SignalRouter, rules ...SignalRule) *SignalRoute {
+	rt := &SignalRoute{
+		router: r,
+		rules:  rules,
+		ch:     make(chan *dbus.Signal, routeQueue),
+		done:   make(chan struct{}),
+	}
+
+	r.add(rt)
+
+	return rt
+}
+
+func TestSignalRouterDispatch(t *testing.T) {
+	properties := "org.freedesktop.DBus.Properties.PropertiesChanged"
+	added := "org.freedesktop.DBus.ObjectManager.InterfacesAdded"
+
+	tests := []struct {
+		name   string
+		rule   SignalRule
+		signal dbus.Signal
+		want   bool
+	}{
+		{
+			"any",
+			SignalRule{},
+			dbus.Signal{Path: "/org/bluez/hci0", Name: properties},
+			true,
+		},
+		{
+			"exact path",
+			SignalRule{Path: "/org/bluez/hci0"},
+			dbus.Signal{Path: "/org/bluez/hci0", Name: properties},
+			true,
+		},
+		{
+			"other path",
+			SignalRule{Path: "/org/bluez/hci0"},
+			dbus.Signal{Path: "/org/bluez/hci1", Name: properties},
+			false,
+		},
+		{
+			"path is not a namespace",
+			SignalRule{Path: "/org/bluez/hci0"},
+			dbus.Signal{Path: "/org/bluez/hci0/dev_AA", Name: properties},
+			false,
+		},
+		{
+			"namespace itself",
+			SignalRule{PathNamespace: "/org/bluez/hci0"},
+			dbus.Signal{Path: "/org/bluez/hci0", Name: properties},
+			true,
+		},
+		{
+			"below namespace",
+			SignalRule{PathNamespace: "/org/bluez/hci0"},
+			dbus.Signal{Path: "/org/bluez/hci0/dev_AA/service0010", Name: properties},
+			true,
+		},
+		{
+			"namespace prefix is not a parent",
+			SignalRule{PathNamespace: "/org/bluez/hci0"},
+			dbus.Signal{Path: "/org/bluez/hci01", Name: properties},
+			false,
+		},
+		{
+			"root namespace",
+			SignalRule{PathNamespace: "/"},
+			dbus.Signal{Path: "/org/bluez/hci0", Name: properties},
+			true,
+		},
+		{
+			"interface",
+			SignalRule{Interface: "org.freedesktop.DBus.Properties"},
+			dbus.Signal{Path: "/", Name: properties},
+			true,
+		},
+		{
+			"other interface",
+			SignalRule{Interface: "org.freedesktop.DBus.Properties"},
+			dbus.Signal{Path: "/", Name: added},
+			false,
+		},
+		{
+			"member",
+			SignalRule{Interface: "org.freedesktop.DBus.ObjectManager", Member: "InterfacesAdded"},
+			dbus.Signal{Path: "/", Name: added},
+			true,
+		},
+		{
+			"other member",
+			SignalRule{Interface: "org.freedesktop.DBus.ObjectManager", Member: "InterfacesRemoved"},
+			dbus.Signal{Path: "/", Name: added},
+			false,
+		},
+		{
+			"arg0",
+			SignalRule{PathNamespace: "/org/bluez", Arg0: ifaceDevice1},
+			dbus.Signal{Path: "/org/bluez/hci0/dev_AA", Name: properties, Body: []interface{}{ifaceDevice1}},
+			true,
+		},
+		{
+			"other arg0",
+			SignalRule{PathNamespace: "/org/bluez", Arg0: ifaceDevice1},
+			dbus.Signal{Path: "/org/bluez/hci0", Name: properties, Body: []interface{}{ifaceAdapter1}},
+			false,
+		},
+		{
+			"arg0 without body",
+			SignalRule{Arg0: ifaceDevice1},
+			dbus.Signal{Path: "/", Name: properties},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &SignalRouter{routes: make(map[routeKey]map[*SignalRoute]bool)}
+			rt := addRoute(r, tt.rule)
+
+			r.dispatch(&tt.signal)
+
+			if got := len(rt.ch) == 1; got != tt.want {
+				t.Errorf("got delivered %v, want %v (%d queued)", got, tt.want, len(rt.ch))
+			}
+		})
+	}
+}
+
+func TestSignalRouterDrops(t *testing.T) {
+	r := &SignalRouter{routes: make(map[routeKey]map[*SignalRoute]bool)}
+	rt := addRoute(r, SignalRule{})
+
+	for i := 0; i < routeQueue+3; i++ {
+		r.dispatch(&dbus.Signal{Path: "/", Name: "a.b"})
+	}
+
+	if len(rt.ch) != routeQueue {
+		t.Errorf("got %d queued, want %d", len(rt.ch), routeQueue)
+	}
+
+	if rt.Dropped() != 3 {
+		t.Errorf("got %d dropped, want 3", rt.Dropped())
+	}
+}
+
+func TestSignalRouterRoutesOrdered(t *testing.T) {
+	properties := "org.freedesktop.DBus.Properties.PropertiesChanged"
+	added := "org.freedesktop.DBus.ObjectManager.InterfacesAdded"
+
+	r := &SignalRouter{routes: make(map[routeKey]map[*SignalRoute]bool)}
+	rt := addRoute(r,
+		SignalRule{Interface: "org.freedesktop.DBus.ObjectManager", Member: "InterfacesAdded"},
+		SignalRule{Interface: "org.freedesktop.DBus.Properties", Member: "PropertiesChanged", Arg0: ifaceDevice1},
+		SignalRule{Interface: "org.freedesktop.DBus.Properties", Member: "PropertiesChanged", Arg0: ifaceAdapter1},
+		SignalRule{PathNamespace: "/org/bluez"},
+	)
+
+	signals := []*dbus.Signal{
+		{Path: "/org/bluez/hci0/dev_AA", Name: properties, Body: []interface{}{ifaceDevice1}},
+		{Path: "/", Name: added},
+		{Path: "/org/bluez/hci0", Name: properties, Body: []interface{}{ifaceAdapter1}},
+		{Path: "/other", Name: properties, Body: []interface{}{ifaceGattCharacteristic1}},
+	}
+
+	for _, s := range signals {
+		r.dispatch(s)
+	}
+
+	// Each signal once, the first matches two rules, and in order
+	if len(rt.ch) != 3 {
+		t.Fatalf("got %d queued, want 3", len(rt.ch))
+	}
+
+	for _, want := range signals[:3] {
+		if got := <-rt.ch; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestParentPath(t *testing.T) {
+	tests := map[dbus.ObjectPath]dbus.ObjectPath{
+		"/org/bluez/hci0": "/org/bluez",
+		"/org":            "/",
+		"/":               "/",
+	}
+
+	for p, want := range tests {
+		if got := parentPath(p); got != want {
+			t.Errorf("parentPath(%q) got %q, want %q", p, got, want)
+		}
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// reconnectMin and reconnectMax bound the exponential backoff
+	// between reconnection attempts
+	reconnectMin = time.Second
+	reconnectMax = 5 * time.Minute
+
+	// resolveTimeout is how long to wait for BlueZ to resolve the GATT
+	// services of a freshly connected device
+	resolveTimeout = 30 * time.Second
+
+	// disconnectTimeout bounds dropping a link that failed to set up
+	disconnectTimeout = 5 * time.Second
+)
+
+const (
+	StateConnecting ConnectionState = iota
+	StateConnected
+	StateDisconnected
+	StateStale
+)
+
+type (
+	ConnectionState int
+
+	// ConnectionEvent reports a change of the connection state of a
+	// device.
+	ConnectionEvent struct {
+		Device  string
+		Alias   string
+		State   ConnectionState
+		Attempt int    `json:",omitempty"`
+		Err     string `json:",omitempty"`
+		Detail  string `json:",omitempty"`
+		T       time.Time
+	}
+
+	// Supervisor keeps a device connected. It watches the Connected and
+	// ServicesResolved properties of the device and reconnects, with
+	// exponential backoff, whenever the link drops. It also runs a
+	// watchdog that first restarts the notifications, then reconnects,
+	// when the device stops sending samples while still connected.
+	Supervisor struct {
+		manager  *ObjectManager
+		dev      *Device
+		cfg      DeviceConfig
+		sessions *Sessions
+
+		// observe receives the measurements and publish the
+		// connection events, either may be nil
+		observe func(Measurement)
+		publish func(ConnectionEvent)
+
+		id    string
+		alias string
+
+		// changes reports the Connected and ServicesResolved changes
+		// until stopWatch is called
+		changes   <-chan PropertyChange
+		stopWatch context.CancelFunc
+
+		// staleAfter is the watchdog window, 0 if disabled, and
+		// samples is kicked for every measurement received
+		staleAfter time.Duration
+		samples    chan struct{}
+	}
+
+	// linkChange holds the Device1 properties the supervisor follows
+	linkChange struct {
+		Connected        *bool
+		ServicesResolved *bool
+	}
+)
+
+var (
+	ErrDisconnected       = errors.New("device disconnected")
+	ErrServicesUnresolved = errors.New("device services no longer resolved")
+	ErrResolveTimeout     = errors.New("timed out resolving device services")
+	ErrStale              = errors.New("device stopped sending samples")
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	case StateStale:
+		return "stale"
+	}
+
+	return "unknown"
+}
+
+func (s ConnectionState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func NewSupervisor(manager *ObjectManager, dev *Device, cfg DeviceConfig, sessions *Sessions,
+	observe func(Measurement), publish func(ConnectionEvent)) (*Supervisor, error) {
+	id, err := dev.Address()
+	if err != nil {
+		return nil, err
+	}
+
+	alias, err := dev.Alias()
+	if err != nil {
+		alias = id
+	}
+
+	staleAfter, err := cfg.StaleAfterDuration()
+	if err != nil {
+		return nil, err
+	}
+
+	sv := &Supervisor{
+		manager:  manager,
+		dev:      dev,
+		cfg:      cfg,
+		sessions: sessions,
+		observe:  observe,
+		publish:  publish,
+		id:       id,
+		alias:    alias,
+
+		staleAfter: staleAfter,
+		samples:    make(chan struct{}, 1),
+	}
+
+	// The watch outlives ctx of Run, it's stopped when Run returns
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+
+	sv.changes, err = dev.WatchProperties(watchCtx, "Connected", "ServicesResolved")
+	if err != nil {
+		stopWatch()
+		return nil, err
+	}
+
+	sv.stopWatch = stopWatch
+
+	return sv, nil
+}
+
+// Run connects the device and keeps it connected until ctx is done.
+func (sv *Supervisor) Run(ctx context.Context, sink MeasurementSink, history HistorySource) {
+	defer sv.stopWatch()
+
+	backoff := reconnectMin
+
+	for attempt := 1; ; attempt++ {
+		sv.emit(StateConnecting, attempt, nil, "")
+
+		s, err := sv.connect(ctx)
+		if err != nil {
+			log.Printf("[%s] Connection attempt %d failed, %v", sv.id, attempt, err)
+			sv.emit(StateDisconnected, attempt, err, "")
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+			if backoff > reconnectMax {
+				backoff = reconnectMax
+			}
+
+			continue
+		}
+
+		attempt, backoff = 0, reconnectMin
+
+		sv.emit(StateConnected, 0, nil, "")
+
+		sessCtx, cancel := context.WithCancel(ctx)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Run(sessCtx, sink, history, sv.sample)
+		}()
+
+		err = sv.waitDisconnect(ctx, s)
+
+		cancel()
+		wg.Wait()
+
+		sv.sessions.Remove(s.ID)
+		if err := s.Close(); err != nil {
+			debug("[%s] Session.Close() failed, %v", sv.id, err)
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("[%s] Connection lost, %v", sv.id, err)
+		sv.emit(StateDisconnected, 0, err, "")
+	}
+}
+
+// sample kicks the watchdog and passes m on to the observer.
+func (sv *Supervisor) sample(m Measurement) {
+	select {
+	case sv.samples <- struct{}{}:
+	default:
+	}
+
+	if sv.observe != nil {
+		sv.observe(m)
+	}
+}
+
+// connect connects the device, waits for its services to be resolved and
+// starts a session for it.
+func (sv *Supervisor) connect(ctx context.Context) (*Session, error) {
+	sv.drainChanges()
+
+	connected, err := sv.dev.Connected()
+	if err != nil {
+		return nil, err
+	}
+
+	if !connected {
+		if err := sv.dev.Connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	s, err := sv.startSession(ctx)
+	if err != nil {
+		// A half set up link, e.g. logged in or with notifications
+		// started, isn't reused, the next attempt starts afresh
+		sv.disconnect()
+		return nil, err
+	}
+
+	sv.sessions.Add(s)
+
+	return s, nil
+}
+
+// startSession starts a session once the services of the connected device
+// are resolved.
+func (sv *Supervisor) startSession(ctx context.Context) (*Session, error) {
+	if err := sv.waitResolved(ctx); err != nil {
+		return nil, err
+	}
+
+	// BlueZ recreates the GATT objects when a device reconnects, they're
+	// picked up from the live tree
+	services, err := sv.manager.DeviceServices(string(sv.dev.Path()))
+	if err != nil {
+		return nil, err
+	}
+
+	sv.dev.setServices(services)
+
+	return NewSession(sv.dev, sv.cfg)
+}
+
+// disconnect drops the link to the device, which also ends the notification
+// sessions BlueZ holds for it.
+func (sv *Supervisor) disconnect() {
+	ctx, cancel := context.WithTimeout(context.Background(), disconnectTimeout)
+	defer cancel()
+
+	if err := sv.dev.Disconnect(ctx); err != nil {
+		debug("[%s] Disconnect() failed, %v", sv.id, err)
+	}
+}
+
+// waitResolved waits for the ServicesResolved property to become true. It
+// follows the live tree rather than the device's own signals, which may be
+// handled before the tree has the GATT objects of the device.
+func (sv *Supervisor) waitResolved(ctx context.Context) error {
+	path := string(sv.dev.Path())
+
+	timeout := time.NewTimer(resolveTimeout)
+	defer timeout.Stop()
+
+	for {
+		changed := sv.manager.Changed()
+
+		if sv.manager.ServicesResolved(path) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-timeout.C:
+			return ErrResolveTimeout
+
+		case <-changed:
+
+		case c := <-sv.changes:
+			if link := sv.link(c); link.Connected != nil && !*link.Connected {
+				return ErrDisconnected
+			}
+		}
+	}
+}
+
+// waitDisconnect blocks until the device disconnects, loses its resolved
+// services or goes stale, or ctx is done.
+func (sv *Supervisor) waitDisconnect(ctx context.Context, s *Session) error {
+	// Samples from a previous session don't count
+	sv.drainSamples()
+
+	if sv.staleAfter == 0 {
+		// A nil channel never fires, which disables the watchdog
+		return sv.watch(ctx, s, nil, func() {})
+	}
+
+	t := time.NewTimer(sv.staleAfter)
+	defer t.Stop()
+
+	reset := func() {
+		if !t.Stop() {
+			select {
+			case <-t.C:
+			default:
+			}
+		}
+		t.Reset(sv.staleAfter)
+	}
+
+	return sv.watch(ctx, s, t.C, reset)
+}
+
+// watch is the body of waitDisconnect. The first time stale fires the
+// notifications are restarted, the second time without a sample in between
+// the device is given up on so that it is reconnected.
+func (sv *Supervisor) watch(ctx context.Context, s *Session, stale <-chan time.Time, reset func()) error {
+	restarted := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-sv.samples:
+			if restarted {
+				restarted = false
+				log.Printf("[%s] Samples resumed", sv.id)
+				sv.emit(StateConnected, 0, nil, "samples resumed")
+			}
+
+			reset()
+
+		case <-stale:
+			if restarted {
+				log.Printf("[%s] Still stale after restarting notifications, reconnecting", sv.id)
+				sv.emit(StateStale, 0, nil, "still stale after restarting notifications, reconnecting")
+				return ErrStale
+			}
+
+			detail := fmt.Sprintf("no samples for %v", sv.staleAfter)
+			log.Printf("[%s] Stale, %s", sv.id, detail)
+			sv.emit(StateStale, 0, nil, detail)
+
+			nr, ok := s.Thermometer().(NotificationRestarter)
+			if !ok {
+				sv.emit(StateStale, 0, nil, "notifications can't be restarted, reconnecting")
+				return ErrStale
+			}
+
+			log.Printf("[%s] Restarting notifications", sv.id)
+			sv.emit(StateStale, 0, nil, "restarting notifications")
+
+			if err := nr.RestartNotifications(); err != nil {
+				log.Printf("[%s] RestartNotifications() failed, %v", sv.id, err)
+				sv.emit(StateStale, 0, err, "restarting notifications failed, reconnecting")
+				return ErrStale
+			}
+
+			restarted = true
+			reset()
+
+		case c := <-sv.changes:
+			link := sv.link(c)
+
+			if link.Connected != nil && !*link.Connected {
+				return ErrDisconnected
+			}
+
+			if link.ServicesResolved != nil && !*link.ServicesResolved {
+				return ErrServicesUnresolved
+			}
+		}
+	}
+}
+
+func (sv *Supervisor) drainSamples() {
+	select {
+	case <-sv.samples:
+	default:
+	}
+}
+
+// link decodes the Connected and ServicesResolved changes, those that didn't
+// change, or failed to decode, are nil.
+func (sv *Supervisor) link(c PropertyChange) linkChange {
+	var link linkChange
+	if err := c.Decode(&link); err != nil {
+		log.Printf("[%s] Decode() failed, %v", sv.id, err)
+	}
+
+	return link
+}
+
+func (sv *Supervisor) drainChanges() {
+	for {
+		select {
+		case <-sv.changes:
+		default:
+			return
+		}
+	}
+}
+
+func (sv *Supervisor) emit(state ConnectionState, attempt int, err error, detail string) {
+	if sv.publish == nil {
+		return
+	}
+
+	e := ConnectionEvent{
+		Device:  sv.id,
+		Alias:   sv.alias,
+		State:   state,
+		Attempt: attempt,
+		Detail:  detail,
+		T:       time.Now().UTC(),
+	}
+
+	if err != nil {
+		e.Err = err.Error()
+	}
+
+	sv.publish(e)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type (
+	Unit int
+
+	// Temperature is a temperature in the canonical unit, degrees
+	// Celsius. Use In to present it in another unit.
+	Temperature float64
+)
+
+const (
+	Celsius Unit = iota
+	Fahrenheit
+)
+
+var ErrUnknownUnit = errors.New("unknown unit")
+
+// ParseUnit parses "C", "F", "celsius" or "fahrenheit", ignoring case. The
+// empty string is Celsius.
+func ParseUnit(s string) (Unit, error) {
+	switch strings.ToLower(s) {
+	case "", "c", "celsius":
+		return Celsius, nil
+	case "f", "fahrenheit":
+		return Fahrenheit, nil
+	}
+
+	return Celsius, fmt.Errorf("%w: %q", ErrUnknownUnit, s)
+}
+
+func (u Unit) String() string {
+	if u == Fahrenheit {
+		return "F"
+	}
+
+	return "C"
+}
+
+func (u Unit) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+func (u *Unit) UnmarshalText(text []byte) error {
+	v, err := ParseUnit(string(text))
+	if err != nil {
+		return err
+	}
+
+	*u = v
+
+	return nil
+}
+
+// NewTemperature converts v, given in unit u, to a Temperature.
+func NewTemperature(v float64, u Unit) Temperature {
+	if u == Fahrenheit {
+		return Temperature((v - 32) * 5 / 9)
+	}
+
+	return Temperature(v)
+}
+
+// In returns the temperature in unit u.
+func (t Temperature) In(u Unit) float64 {
+	if u == Fahrenheit {
+		return float64(t)*9/5 + 32
+	}
+
+	return float64(t)
+}
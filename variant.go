@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	dbus "github.com/godbus/dbus/v5"
+)
+
+type (
+	// PropertyTypeError reports a property whose value doesn't have the
+	// type expected.
+	PropertyTypeError struct {
+		Property string
+		Want     string
+		Got      string
+	}
+)
+
+var (
+	ErrPropertyMissing = errors.New("property missing")
+	ErrPropertyType    = errors.New("property has unexpected type")
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	variantType  = reflect.TypeOf(dbus.Variant{})
+)
+
+func (e *PropertyTypeError) Error() string {
+	return fmt.Sprintf("%s: want %s, got %s", e.Property, e.Want, e.Got)
+}
+
+func (e *PropertyTypeError) Unwrap() error {
+	return ErrPropertyType
+}
+
+// decodeValue stores the property value v, which may be wrapped in one or
+// more variants, in dst. On top of values of the same type it converts
+//
+//   - object paths to strings
+//   - uint32 seconds, the unit of BlueZ timeouts, to time.Duration
+//   - slices and maps element by element, e.g. map[uint16]dbus.Variant to
+//     map[uint16][]byte
+//   - values to pointers to them
+//
+// name is only used for errors.
+func decodeValue(name string, v interface{}, dst reflect.Value) error {
+	for {
+		variant, ok := v.(dbus.Variant)
+		if !ok {
+			break
+		}
+
+		v = variant.Value()
+	}
+
+	src := reflect.ValueOf(v)
+	t := dst.Type()
+
+	if !src.IsValid() {
+		return &PropertyTypeError{Property: name, Want: t.String(), Got: "nothing"}
+	}
+
+	switch {
+	case t == variantType:
+		dst.Set(reflect.ValueOf(dbus.MakeVariant(v)))
+		return nil
+
+	case t == durationType:
+		if secs, ok := v.(uint32); ok {
+			dst.SetInt(int64(time.Duration(secs) * time.Second))
+			return nil
+		}
+
+	case src.Type().AssignableTo(t):
+		dst.Set(src)
+		return nil
+
+	case t.Kind() == reflect.Ptr:
+		e := reflect.New(t.Elem())
+		if err := decodeValue(name, v, e.Elem()); err != nil {
+			return err
+		}
+
+		dst.Set(e)
+		return nil
+
+	case t.Kind() == reflect.String && src.Type() == reflect.TypeOf(dbus.ObjectPath("")):
+		dst.SetString(string(v.(dbus.ObjectPath)))
+		return nil
+
+	case t.Kind() == reflect.Slice && src.Kind() == reflect.Slice:
+		s := reflect.MakeSlice(t, src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			if err := decodeValue(fmt.Sprintf("%s[%d]", name, i), src.Index(i).Interface(), s.Index(i)); err != nil {
+				return err
+			}
+		}
+
+		dst.Set(s)
+		return nil
+
+	case t.Kind() == reflect.Map && src.Kind() == reflect.Map && src.Type().Key() == t.Key():
+		m := reflect.MakeMapWithSize(t, src.Len())
+		iter := src.MapRange()
+		for iter.Next() {
+			e := reflect.New(t.Elem()).Elem()
+			if err := decodeValue(fmt.Sprintf("%s[%v]", name, iter.Key()), iter.Value().Interface(), e); err != nil {
+				return err
+			}
+
+			m.SetMapIndex(iter.Key(), e)
+		}
+
+		dst.Set(m)
+		return nil
+	}
+
+	return &PropertyTypeError{Property: name, Want: t.String(), Got: src.Type().String()}
+}
+
+// DecodeProperties decodes props, e.g. the result of GetAll or of a
+// PropertiesChanged signal, into the struct dst points to. Fields are
+// matched by name, or by a `dbus:"Name"` tag, a tag of "-" skips the field.
+// Pointer, slice and map fields are optional and left nil if the property is
+// missing, for other fields ErrPropertyMissing is returned. Every property
+// is decoded even if some fail, the first error is returned.
+func DecodeProperties(props map[string]dbus.Variant, dst interface{}) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("DecodeProperties: %T is not a pointer to a struct", dst)
+	}
+
+	rv = rv.Elem()
+	rt := rv.Type()
+
+	var first error
+
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("dbus"); ok {
+			if tag == "-" {
+				continue
+			}
+
+			name = tag
+		}
+
+		v, ok := props[name]
+		if !ok {
+			switch f.Type.Kind() {
+			case reflect.Ptr, reflect.Slice, reflect.Map:
+			default:
+				if first == nil {
+					first = fmt.Errorf("%w: %s", ErrPropertyMissing, name)
+				}
+			}
+
+			continue
+		}
+
+		if err := decodeValue(name, v, rv.Field(i)); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	dbus "github.com/godbus/dbus/v5"
+)
+
+func TestDecodeValue(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		dst  interface{}
+		want interface{}
+	}{
+		{"string", dbus.MakeVariant("BBQ"), new(string), "BBQ"},
+		{"nested variant", dbus.MakeVariant(dbus.MakeVariant("BBQ")), new(string), "BBQ"},
+		{"object path", dbus.ObjectPath("/org/bluez/hci0"), new(string), "/org/bluez/hci0"},
+		{"int16", int16(-42), new(int16), int16(-42)},
+		{"uint16", uint16(0xfff6), new(uint16), uint16(0xfff6)},
+		{"uint32", uint32(1 << 20), new(uint32), uint32(1 << 20)},
+		{"duration", uint32(180), new(time.Duration), 3 * time.Minute},
+		{"pointer", dbus.MakeVariant(true), new(*bool), func() *bool { b := true; return &b }()},
+		{"variant", "BBQ", new(dbus.Variant), dbus.MakeVariant("BBQ")},
+		{
+			"object path slice",
+			[]dbus.ObjectPath{"/a", "/b"},
+			new([]string),
+			[]string{"/a", "/b"},
+		},
+		{
+			"map of variants",
+			map[uint16]dbus.Variant{0x0001: dbus.MakeVariant([]byte{1, 2})},
+			new(map[uint16][]byte),
+			map[uint16][]byte{0x0001: {1, 2}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := reflect.ValueOf(tt.dst).Elem()
+			if err := decodeValue(tt.name, tt.v, dst); err != nil {
+				t.Fatalf("decodeValue() failed, %v", err)
+			}
+
+			if got := dst.Interface(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeValueTypeError(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		dst  interface{}
+	}{
+		{"narrower int", int32(1), new(int16)},
+		{"signedness", uint16(1), new(int16)},
+		{"string to bool", dbus.MakeVariant("true"), new(bool)},
+		{"non uint32 duration", int64(1), new(time.Duration)},
+		{"slice element", []interface{}{"a", 1}, new([]string)},
+		{"map key", map[string]dbus.Variant{"a": dbus.MakeVariant(1)}, new(map[uint16]int)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := decodeValue(tt.name, tt.v, reflect.ValueOf(tt.dst).Elem())
+			if !errors.Is(err, ErrPropertyType) {
+				t.Fatalf("got %v, want ErrPropertyType", err)
+			}
+
+			var typeErr *PropertyTypeError
+			if !errors.As(err, &typeErr) {
+				t.Fatalf("got %T, want *PropertyTypeError", err)
+			}
+		})
+	}
+}
+
+func TestDecodeProperties(t *testing.T) {
+	type props struct {
+		Address string
+		Name    *string
+		RSSI    *int16
+		UUIDs   []string      `dbus:"UUIDs"`
+		Timeout time.Duration `dbus:"DiscoverableTimeout"`
+		Ignored string        `dbus:"-"`
+	}
+
+	t.Run("all present", func(t *testing.T) {
+		var p props
+		err := DecodeProperties(map[string]dbus.Variant{
+			"Address":             dbus.MakeVariant("AA:BB:CC:DD:EE:FF"),
+			"Name":                dbus.MakeVariant("iBBQ"),
+			"RSSI":                dbus.MakeVariant(int16(-60)),
+			"UUIDs":               dbus.MakeVariant([]string{"0000fff0-0000-1000-8000-00805f9b34fb"}),
+			"DiscoverableTimeout": dbus.MakeVariant(uint32(30)),
+			"Ignored":             dbus.MakeVariant("x"),
+		}, &p)
+		if err != nil {
+			t.Fatalf("DecodeProperties() failed, %v", err)
+		}
+
+		if p.Address != "AA:BB:CC:DD:EE:FF" || p.Name == nil || *p.Name != "iBBQ" ||
+			p.RSSI == nil || *p.RSSI != -60 || len(p.UUIDs) != 1 || p.Timeout != 30*time.Second {
+			t.Errorf("got %+v", p)
+		}
+
+		if p.Ignored != "" {
+			t.Errorf("Ignored = %q, want it skipped", p.Ignored)
+		}
+	})
+
+	t.Run("optional fields absent", func(t *testing.T) {
+		var p props
+		err := DecodeProperties(map[string]dbus.Variant{
+			"Address":             dbus.MakeVariant("AA:BB:CC:DD:EE:FF"),
+			"DiscoverableTimeout": dbus.MakeVariant(uint32(0)),
+		}, &p)
+		if err != nil {
+			t.Fatalf("DecodeProperties() failed, %v", err)
+		}
+
+		if p.Name != nil || p.RSSI != nil || p.UUIDs != nil {
+			t.Errorf("got %+v, want optional fields nil", p)
+		}
+	})
+
+	t.Run("required field absent", func(t *testing.T) {
+		var p props
+		err := DecodeProperties(map[string]dbus.Variant{
+			"DiscoverableTimeout": dbus.MakeVariant(uint32(0)),
+		}, &p)
+		if !errors.Is(err, ErrPropertyMissing) {
+			t.Fatalf("got %v, want ErrPropertyMissing", err)
+		}
+	})
+
+	t.Run("mismatched type", func(t *testing.T) {
+		var p props
+		err := DecodeProperties(map[string]dbus.Variant{
+			"Address":             dbus.MakeVariant("AA:BB:CC:DD:EE:FF"),
+			"Name":                dbus.MakeVariant(uint16(1)),
+			"RSSI":                dbus.MakeVariant(int16(-60)),
+			"DiscoverableTimeout": dbus.MakeVariant(uint32(0)),
+		}, &p)
+		if !errors.Is(err, ErrPropertyType) {
+			t.Fatalf("got %v, want ErrPropertyType", err)
+		}
+
+		// The other properties are still decoded
+		if p.Name != nil || p.RSSI == nil || *p.RSSI != -60 {
+			t.Errorf("got %+v", p)
+		}
+	})
+
+	t.Run("not a struct pointer", func(t *testing.T) {
+		var p props
+		if err := DecodeProperties(nil, p); err == nil {
+			t.Fatal("got nil, want an error")
+		}
+	})
+}
@@ -1,15 +1,67 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// observerQueue is how many messages may be waiting for a websocket client
+// before it starts missing some.
+const observerQueue = 16
+
 type (
+	// target is the body of a probe target request. The temperatures are
+	// given in Unit, Celsius if left out.
+	target struct {
+		Low  float64 `json:"low"`
+		High float64 `json:"high"`
+		Unit Unit    `json:"unit"`
+	}
+
+	// measurementView is a Measurement as presented to a client that has
+	// picked its display unit.
+	measurementView struct {
+		Device       string
+		Alias        string
+		Temperatures []readingView
+		T            time.Time
+		Unit         Unit
+	}
+
+	readingView struct {
+		Value     float64
+		Unplugged bool
+	}
+
+	// measurementFrame and connectionFrame are the websocket messages, Type
+	// tells the client which of the two it received.
+	measurementFrame struct {
+		Type string `json:"type"`
+		measurementView
+	}
+
+	connectionFrame struct {
+		Type string `json:"type"`
+		ConnectionEvent
+	}
+
+	// Controllers looks up the target controller of a device by its ID.
+	Controllers interface {
+		Controller(id string) (TargetController, bool)
+	}
+
 	Web struct {
+		ctls     Controllers
+		scanner  Scanner
 		upgrader websocket.Upgrader
 		events   chan Measurement
 		server   *http.Server
@@ -18,7 +70,8 @@ type (
 		observers []observer
 	}
 
-	observer chan<- Measurement
+	// observer receives Measurements and ConnectionEvents
+	observer chan<- interface{}
 
 	subject interface {
 		addObserver(observer)
@@ -27,10 +80,14 @@ type (
 	}
 )
 
-func NewWeb() *Web {
+// NewWeb starts the web server. Probe targets are programmed through the
+// controllers found in ctls and discovery sessions are run with scanner.
+func NewWeb(cfg WebConfig, ctls Controllers, scanner Scanner) *Web {
 	mux := http.NewServeMux()
 
 	w := &Web{
+		ctls:    ctls,
+		scanner: scanner,
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true
@@ -39,12 +96,14 @@ func NewWeb() *Web {
 		mut:       sync.RWMutex{},
 		observers: make([]observer, 0),
 		server: &http.Server{
-			Addr:    ":9000",
+			Addr:    cfg.Addr,
 			Handler: mux,
 		},
 	}
 
 	mux.HandleFunc("/", w.handler)
+	mux.HandleFunc("/api/devices/", w.handleDevice)
+	mux.HandleFunc("/api/scan", w.handleScan)
 
 	go w.server.ListenAndServe()
 
@@ -55,16 +114,27 @@ func (w *Web) PushMeasurement(m Measurement) {
 	w.notifyAll(m)
 }
 
+func (w *Web) PushConnectionEvent(e ConnectionEvent) {
+	w.notifyAll(e)
+}
+
 func (w *Web) Close() {
 	w.server.Close()
 }
 
-func (w *Web) notifyAll(m Measurement) {
+// notifyAll hands m to every observer without blocking, an observer whose
+// queue is full misses m rather than holding up the supervisors and sessions
+// that push to it.
+func (w *Web) notifyAll(m interface{}) {
 	w.mut.RLock()
 	defer w.mut.RUnlock()
 
 	for _, o := range w.observers {
-		o <- m
+		select {
+		case o <- m:
+		default:
+			debug("observer queue full, dropping %T", m)
+		}
 	}
 }
 
@@ -87,8 +157,17 @@ func (w *Web) removeObserver(o observer) {
 	}
 }
 
+// handler streams measurements to a websocket client. The client picks its
+// display unit with the unit query parameter, e.g. /?unit=F.
 func (web *Web) handler(w http.ResponseWriter, r *http.Request) {
 	log.Print("Client connected")
+
+	unit, err := ParseUnit(r.URL.Query().Get("unit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	c, err := web.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Print("Upgrade() failed, :", err)
@@ -96,17 +175,188 @@ func (web *Web) handler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer c.Close()
 
-	ch := make(chan Measurement)
-	defer close(ch)
-
-	o := make(chan Measurement, 1)
+	o := make(chan interface{}, observerQueue)
 	web.addObserver(o)
 	defer web.removeObserver(o)
 
 	for m := range o {
+		switch mm := m.(type) {
+		case Measurement:
+			m = measurementFrame{"measurement", newMeasurementView(mm, unit)}
+		case ConnectionEvent:
+			m = connectionFrame{"connection", mm}
+		}
+
 		if err := c.WriteJSON(m); err != nil {
 			log.Print("WriteJSON() failed, :", err)
 			return
 		}
 	}
 }
+
+// handleScan runs a discovery session for as long as the websocket client
+// stays connected, or for the duration query parameter, e.g.
+// /api/scan?duration=30s, and streams the DiscoveryEvents.
+func (web *Web) handleScan(w http.ResponseWriter, r *http.Request) {
+	var duration time.Duration
+	if d := r.URL.Query().Get("duration"); d != "" {
+		var err error
+		if duration, err = time.ParseDuration(d); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	c, err := web.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Print("Upgrade() failed, :", err)
+		return
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// The client doesn't send anything, reading only detects that it
+	// went away
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := c.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	duplicates := true
+	events, err := web.scanner.Discover(ctx, DiscoveryOptions{
+		Filter: &DiscoveryFilter{
+			Transport:     TransportLE,
+			DuplicateData: &duplicates,
+		},
+		Duration: duration,
+	})
+	if err != nil {
+		log.Print("Discover() failed, ", err)
+		c.WriteJSON(map[string]string{"Err": err.Error()})
+		return
+	}
+
+	for e := range events {
+		if err := c.WriteJSON(e); err != nil {
+			log.Print("WriteJSON() failed, :", err)
+			return
+		}
+	}
+}
+
+func newMeasurementView(m Measurement, unit Unit) measurementView {
+	v := measurementView{
+		Temperatures: make([]readingView, len(m.Temperatures)),
+		Device:       m.Device,
+		Alias:        m.Alias,
+		T:            m.T,
+		Unit:         unit,
+	}
+
+	for i, r := range m.Temperatures {
+		v.Temperatures[i] = readingView{
+			Value:     r.Value.In(unit),
+			Unplugged: r.Unplugged,
+		}
+	}
+
+	return v
+}
+
+// handleDevice routes the per device API:
+//
+//	PUT  /api/devices/{device}/probes/{probe}/target
+//	POST /api/devices/{device}/alarm/silence
+//
+// where device is the device ID and probe counts from 1, as in the InfluxDB
+// field names.
+func (web *Web) handleDevice(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/devices/"), "/")
+
+	switch {
+	case len(parts) == 4 && parts[1] == "probes" && parts[3] == "target":
+		web.handleProbeTarget(w, r, parts[0], parts[2])
+	case len(parts) == 3 && parts[1] == "alarm" && parts[2] == "silence":
+		web.handleSilenceAlarm(w, r, parts[0])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (web *Web) controller(w http.ResponseWriter, r *http.Request, device string) (TargetController, bool) {
+	ctl, ok := web.ctls.Controller(device)
+	if !ok {
+		http.Error(w, "unknown device or not supported by thermometer", http.StatusNotFound)
+		return nil, false
+	}
+
+	return ctl, true
+}
+
+func (web *Web) handleProbeTarget(w http.ResponseWriter, r *http.Request, device, p string) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctl, ok := web.controller(w, r, device)
+	if !ok {
+		return
+	}
+
+	probe, err := strconv.Atoi(p)
+	if err != nil || probe < 1 {
+		http.Error(w, "invalid probe", http.StatusBadRequest)
+		return
+	}
+
+	var t target
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	low, high := NewTemperature(t.Low, t.Unit), NewTemperature(t.High, t.Unit)
+	if err := ctl.SetProbeTarget(probe-1, low, high); err != nil {
+		log.Print("SetProbeTarget() failed, ", err)
+		web.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (web *Web) handleSilenceAlarm(w http.ResponseWriter, r *http.Request, device string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctl, ok := web.controller(w, r, device)
+	if !ok {
+		return
+	}
+
+	if err := ctl.SilenceAlarm(); err != nil {
+		log.Print("SilenceAlarm() failed, ", err)
+		web.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (web *Web) writeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrInvalidProbe) || errors.Is(err, ErrInvalidTarget) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}